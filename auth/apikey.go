@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greet/filewatch"
+	"greet/logging"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// APIKeyHeader is the metadata key carrying API keys
+const APIKeyHeader = "x-api-key"
+
+// APIKeyRecord is an API key as kept in the key store. Only a salted hash
+// of the secret part of the key is stored.
+type APIKeyRecord struct {
+	ID string `json:"id"`
+	// Name of the caller owning the key, used as the principal subject
+	Name  string   `json:"name"`
+	Salt  string   `json:"salt"`
+	Hash  string   `json:"hash"`
+	Roles []string `json:"roles,omitempty"`
+	// Scopes are the full methods the key may call, such as
+	// "/blog.BlogService/ReadBlog", "/blog.BlogService/*" or "*"
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	NotBefore time.Time `json:"not_before"`
+	// NotAfter is the end of validity of the key, zero for no end
+	NotAfter time.Time `json:"not_after,omitempty"`
+	Revoked  bool      `json:"revoked,omitempty"`
+}
+
+// ValidAt reports whether the key can be used at t
+func (r *APIKeyRecord) ValidAt(t time.Time) bool {
+	return !r.Revoked && !t.Before(r.NotBefore) && (r.NotAfter.IsZero() || t.Before(r.NotAfter))
+}
+
+// Allows reports whether the scopes of the key include fullMethod
+func (r *APIKeyRecord) Allows(fullMethod string) bool {
+	return MatchMethod(r.Scopes, fullMethod)
+}
+
+// MatchMethod reports whether fullMethod matches one of patterns, which are
+// full method names where "*" matches one path element, for instance
+// "/blog.BlogService/*", or "*" alone which matches every method
+func MatchMethod(patterns []string, fullMethod string) bool {
+	for _, p := range patterns {
+		if p == "*" {
+			return true
+		}
+		if ok, _ := path.Match(p, fullMethod); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyStore authenticates requests carrying an x-api-key metadata with
+// the keys of a JSON file. Several keys can be valid for the same caller at
+// once, which allows rotating keys without downtime.
+type APIKeyStore struct {
+	path string
+	// Now returns the current time, defaults to time.Now
+	Now func() time.Time
+
+	mu      sync.RWMutex
+	records map[string]*APIKeyRecord
+}
+
+// OpenAPIKeyStore loads the key store from path, a missing file is an
+// empty store
+func OpenAPIKeyStore(path string) (*APIKeyStore, error) {
+	s := &APIKeyStore{path: path, records: map[string]*APIKeyRecord{}}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the store file again, keeping the current keys on error
+func (s *APIKeyStore) Reload() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		data = []byte("[]")
+	} else if err != nil {
+		return err
+	}
+	var list []*APIKeyRecord
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("auth: invalid API key store %v: %v", s.path, err)
+	}
+	records := map[string]*APIKeyRecord{}
+	for _, r := range list {
+		records[r.ID] = r
+	}
+	s.mu.Lock()
+	s.records = records
+	s.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the store whenever its file changes, until the returned
+// watcher is closed
+func (s *APIKeyStore) Watch(interval time.Duration) *filewatch.Watcher {
+	return filewatch.New(interval, func() {
+		if err := s.Reload(); err != nil {
+			logging.Error("Keeping the previous API keys", logging.F("path", s.path), logging.Err(err))
+			return
+		}
+		logging.Info("API keys reloaded", logging.F("path", s.path))
+	}, s.path)
+}
+
+// Save writes the store file atomically, readable by its owner only
+func (s *APIKeyStore) Save() error {
+	data, err := json.MarshalIndent(s.Records(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".apikeys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Records returns the keys of the store sorted by name and creation time
+func (s *APIKeyStore) Records() []APIKeyRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]APIKeyRecord, 0, len(s.records))
+	for _, r := range s.records {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+func (s *APIKeyStore) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Mint creates a key valid from notBefore until notAfter (zero for no end)
+// and returns it. The key is only ever returned here, the store keeps its
+// hash.
+func (s *APIKeyStore) Mint(name string, scopes []string, roles []string, notBefore time.Time, notAfter time.Time) (string, APIKeyRecord, error) {
+	if name == "" {
+		return "", APIKeyRecord{}, errors.New("auth: API key name is required")
+	}
+	id, err := randomBytes(8)
+	if err != nil {
+		return "", APIKeyRecord{}, err
+	}
+	secret, err := randomBytes(32)
+	if err != nil {
+		return "", APIKeyRecord{}, err
+	}
+	salt, err := randomBytes(16)
+	if err != nil {
+		return "", APIKeyRecord{}, err
+	}
+	secretText := base64.RawURLEncoding.EncodeToString(secret)
+	r := &APIKeyRecord{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Salt:      base64.StdEncoding.EncodeToString(salt),
+		Hash:      base64.StdEncoding.EncodeToString(hashSecret(salt, secretText)),
+		Roles:     roles,
+		Scopes:    scopes,
+		CreatedAt: s.now().UTC(),
+		NotBefore: notBefore.UTC(),
+	}
+	if !notAfter.IsZero() {
+		r.NotAfter = notAfter.UTC()
+	}
+	s.mu.Lock()
+	s.records[r.ID] = r
+	s.mu.Unlock()
+	return r.ID + "." + secretText, *r, nil
+}
+
+// Rotate mints a key with the same name, roles and scopes as the key id,
+// and ends the validity of the old key after overlap so that callers have
+// time to switch to the new key
+func (s *APIKeyStore) Rotate(id string, overlap time.Duration, validFor time.Duration) (string, APIKeyRecord, error) {
+	s.mu.RLock()
+	old, ok := s.records[id]
+	s.mu.RUnlock()
+	if !ok {
+		return "", APIKeyRecord{}, fmt.Errorf("auth: unknown API key %q", id)
+	}
+	now := s.now()
+	var notAfter time.Time
+	if validFor > 0 {
+		notAfter = now.Add(validFor)
+	}
+	key, r, err := s.Mint(old.Name, old.Scopes, old.Roles, now, notAfter)
+	if err != nil {
+		return "", APIKeyRecord{}, err
+	}
+	s.mu.Lock()
+	end := now.Add(overlap).UTC()
+	if old.NotAfter.IsZero() || end.Before(old.NotAfter) {
+		old.NotAfter = end
+	}
+	s.mu.Unlock()
+	return key, r, nil
+}
+
+// Revoke disables the key id immediately
+func (s *APIKeyStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[id]
+	if !ok {
+		return fmt.Errorf("auth: unknown API key %q", id)
+	}
+	r.Revoked = true
+	return nil
+}
+
+func (s *APIKeyStore) Authenticate(ctx context.Context, fullMethod string) (*Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(APIKeyHeader)
+	if len(keys) == 0 {
+		return nil, ErrNoCredentials
+	}
+	id, secret := keys[0], ""
+	if i := strings.IndexByte(keys[0], '.'); i >= 0 {
+		id, secret = keys[0][:i], keys[0][i+1:]
+	}
+
+	s.mu.RLock()
+	r, ok := s.records[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, errors.New("unknown API key")
+	}
+	salt, err := base64.StdEncoding.DecodeString(r.Salt)
+	if err != nil {
+		return nil, errors.New("unknown API key")
+	}
+	want, err := base64.StdEncoding.DecodeString(r.Hash)
+	if err != nil || subtle.ConstantTimeCompare(hashSecret(salt, secret), want) != 1 {
+		return nil, errors.New("unknown API key")
+	}
+	if !r.ValidAt(s.now()) {
+		return nil, errors.New("API key is revoked or expired")
+	}
+	if !r.Allows(fullMethod) {
+		return nil, status.Errorf(codes.PermissionDenied, "API key is not allowed to call %v", fullMethod)
+	}
+	return &Principal{Subject: r.Name, Roles: r.Roles}, nil
+}
+
+// Keys are random 256 bits secrets, so a salted SHA-256 is enough to
+// protect them, unlike passwords
+func hashSecret(salt []byte, secret string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(secret))
+	return h.Sum(nil)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
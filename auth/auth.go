@@ -0,0 +1,159 @@
+// Package auth authenticates the callers of the gRPC services and carries
+// their identity, the Principal, in the request context.
+//
+// Authenticators handle one kind of credentials each and are installed on
+// a server with UnaryServerInterceptor and StreamServerInterceptor. Requests
+// without credentials go through without a Principal, handlers which need an
+// identity call Require.
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AdminRole is the role allowed to act on resources owned by others
+const AdminRole = "admin"
+
+// Principal is the authenticated identity of a caller
+type Principal struct {
+	Subject string
+	Roles   []string
+	// Claims holds the raw claims of the credentials, if they carry any
+	Claims map[string]interface{}
+}
+
+// HasRole reports whether the principal was granted role
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the principal has the admin role
+func (p *Principal) IsAdmin() bool {
+	return p.HasRole(AdminRole)
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying p
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of the request, if it was authenticated
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// Require returns the principal of the request or an Unauthenticated error
+func Require(ctx context.Context) (*Principal, error) {
+	p, ok := FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "Authentication required")
+	}
+	return p, nil
+}
+
+// ErrNoCredentials is returned by authenticators when the request carries
+// none of the credentials they handle
+var ErrNoCredentials = errors.New("auth: no credentials")
+
+// Authenticator extracts and verifies the credentials of a request.
+// It returns ErrNoCredentials when the request has no credentials of its
+// kind, and any other error when the credentials are invalid.
+type Authenticator interface {
+	Authenticate(ctx context.Context, fullMethod string) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface
+type AuthenticatorFunc func(ctx context.Context, fullMethod string) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, fullMethod string) (*Principal, error) {
+	return f(ctx, fullMethod)
+}
+
+// Chain returns an authenticator trying each of authenticators in order
+// until one finds credentials in the request
+func Chain(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, fullMethod string) (*Principal, error) {
+		for _, a := range authenticators {
+			p, err := a.Authenticate(ctx, fullMethod)
+			if err != ErrNoCredentials {
+				return p, err
+			}
+		}
+		return nil, ErrNoCredentials
+	})
+}
+
+// Required wraps a so that requests without credentials are rejected
+func Required(a Authenticator) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, fullMethod string) (*Principal, error) {
+		p, err := a.Authenticate(ctx, fullMethod)
+		if err == ErrNoCredentials {
+			return nil, status.Error(codes.Unauthenticated, "Authentication required")
+		}
+		return p, err
+	})
+}
+
+// authenticate returns the context to use for the request, carrying its
+// principal if it has credentials
+func authenticate(ctx context.Context, a Authenticator, fullMethod string) (context.Context, error) {
+	p, err := a.Authenticate(ctx, fullMethod)
+	if err == ErrNoCredentials {
+		return ctx, nil
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "Invalid credentials : %v", err)
+	}
+	return NewContext(ctx, p), nil
+}
+
+// UnaryServerInterceptor authenticates unary calls with a
+func UnaryServerInterceptor(a Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, a, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authenticates streaming calls with a
+func StreamServerInterceptor(a Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), a, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// serverStream overrides the context of a grpc.ServerStream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"context"
+)
+
+// BearerToken is a per-RPC credential sending a JWT in the authorization
+// metadata of every call, to be used with grpc.WithPerRPCCredentials
+type BearerToken struct {
+	Token string
+	// AllowInsecure allows sending the token over plaintext connections
+	AllowInsecure bool
+}
+
+func (t BearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{AuthorizationHeader: "Bearer " + t.Token}, nil
+}
+
+func (t BearerToken) RequireTransportSecurity() bool {
+	return !t.AllowInsecure
+}
+
+// APIKey is a per-RPC credential sending an API key in the x-api-key
+// metadata of every call, to be used with grpc.WithPerRPCCredentials
+type APIKey struct {
+	Key string
+	// AllowInsecure allows sending the key over plaintext connections
+	AllowInsecure bool
+}
+
+func (k APIKey) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{APIKeyHeader: k.Key}, nil
+}
+
+func (k APIKey) RequireTransportSecurity() bool {
+	return !k.AllowInsecure
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata keys read by Header
+const (
+	SubjectHeader = "x-user-id"
+	RolesHeader   = "x-user-roles"
+)
+
+// Header trusts the identity found in the x-user-id and x-user-roles
+// (comma separated) metadata. It performs no verification and must only be
+// used when every request goes through a proxy which authenticates the
+// caller and sets these headers, or for local development.
+type Header struct{}
+
+func (Header) Authenticate(ctx context.Context, fullMethod string) (*Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	subjects := md.Get(SubjectHeader)
+	if len(subjects) == 0 || subjects[0] == "" {
+		return nil, ErrNoCredentials
+	}
+	p := &Principal{Subject: subjects[0]}
+	for _, v := range md.Get(RolesHeader) {
+		for _, r := range strings.Split(v, ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				p.Roles = append(p.Roles, r)
+			}
+		}
+	}
+	return p, nil
+}
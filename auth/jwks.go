@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// jwk is a JSON Web Key (RFC 7517) as found in a JWKS file
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// Symmetric
+	K string `json:"k"`
+}
+
+// verificationKey is a key of a KeySet with the algorithm it verifies
+type verificationKey struct {
+	alg string
+	// *rsa.PublicKey, *ecdsa.PublicKey or []byte
+	key interface{}
+}
+
+// KeySet holds the keys used to verify JWT signatures, by key id
+type KeySet struct {
+	keys map[string]verificationKey
+}
+
+// LoadJWKS reads a JWKS file. RSA keys verify RS256 tokens, P-256 EC keys
+// ES256 tokens and symmetric (oct) keys HS256 tokens.
+func LoadJWKS(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// ParseJWKS parses the content of a JWKS file, see LoadJWKS
+func ParseJWKS(data []byte) (*KeySet, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("auth: invalid JWKS: %v", err)
+	}
+	set := &KeySet{keys: map[string]verificationKey{}}
+	for i, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		vk, err := k.verificationKey()
+		if err != nil {
+			return nil, fmt.Errorf("auth: invalid JWKS key %d (%q): %v", i, k.Kid, err)
+		}
+		if _, dup := set.keys[k.Kid]; dup {
+			return nil, fmt.Errorf("auth: duplicate JWKS key id %q", k.Kid)
+		}
+		set.keys[k.Kid] = vk
+	}
+	return set, nil
+}
+
+func (k jwk) verificationKey() (verificationKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		if n.BitLen() < 2048 || !e.IsInt64() {
+			return verificationKey{}, fmt.Errorf("unsupported RSA key")
+		}
+		return k.withAlg("RS256", &rsa.PublicKey{N: n, E: int(e.Int64())})
+	case "EC":
+		if k.Crv != "P-256" {
+			return verificationKey{}, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return verificationKey{}, fmt.Errorf("point is not on the curve")
+		}
+		return k.withAlg("ES256", &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y})
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		if len(secret) < 32 {
+			return verificationKey{}, fmt.Errorf("HMAC secret shorter than 256 bits")
+		}
+		return k.withAlg("HS256", secret)
+	default:
+		return verificationKey{}, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// withAlg checks the alg of the key, when present, matches its type
+func (k jwk) withAlg(alg string, key interface{}) (verificationKey, error) {
+	if k.Alg != "" && k.Alg != alg {
+		return verificationKey{}, fmt.Errorf("unsupported algorithm %q", k.Alg)
+	}
+	return verificationKey{alg: alg, key: key}, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// lookup returns the key verifying tokens signed with alg and kid. Tokens
+// without kid are accepted when the set has a single key for alg.
+func (s *KeySet) lookup(alg string, kid string) (verificationKey, bool) {
+	if kid != "" {
+		k, ok := s.keys[kid]
+		return k, ok && k.alg == alg
+	}
+	var found verificationKey
+	n := 0
+	for _, k := range s.keys {
+		if k.alg == alg {
+			found = k
+			n++
+		}
+	}
+	return found, n == 1
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// AuthorizationHeader is the metadata key carrying bearer tokens
+const AuthorizationHeader = "authorization"
+
+// JWT authenticates requests carrying an "authorization: Bearer <jwt>"
+// metadata. Tokens must be signed with one of the keys of Keys, be valid
+// at the current time (exp, nbf) and have a sub claim. The roles of the
+// principal are read from the roles claim.
+type JWT struct {
+	Keys *KeySet
+	// Audience, when not empty, must be one of the aud claim values
+	Audience string
+	// Leeway tolerated on the exp and nbf claims for clock skew
+	Leeway time.Duration
+	// Now returns the current time, defaults to time.Now
+	Now func() time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (j *JWT) Authenticate(ctx context.Context, fullMethod string) (*Principal, error) {
+	token, ok := bearerToken(ctx)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	claims, err := j.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return &Principal{Subject: sub, Roles: stringList(claims["roles"]), Claims: claims}, nil
+}
+
+// bearerToken returns the token of the authorization metadata, if any
+func bearerToken(ctx context.Context) (string, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(AuthorizationHeader) {
+		if len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+			return strings.TrimSpace(v[7:]), true
+		}
+	}
+	return "", false
+}
+
+// Verify checks the signature and the validity of token and returns its claims
+func (j *JWT) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+	// The key is looked up by algorithm too, so that a token can never be
+	// verified with a key of another type than the one it was issued for
+	key, ok := j.Keys.lookup(header.Alg, header.Kid)
+	if !ok {
+		return nil, fmt.Errorf("no key to verify %q token with kid %q", header.Alg, header.Kid)
+	}
+	if !verifySignature(key, parts[0]+"."+parts[1], sig) {
+		return nil, errors.New("invalid token signature")
+	}
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	if err := j.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (j *JWT) validate(claims map[string]interface{}) error {
+	now := time.Now()
+	if j.Now != nil {
+		now = j.Now()
+	}
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return errors.New("token has no expiration")
+	}
+	if now.After(exp.Add(j.Leeway)) {
+		return errors.New("token is expired")
+	}
+	if _, present := claims["nbf"]; present {
+		nbf, ok := numericDate(claims["nbf"])
+		if !ok {
+			return errors.New("token has an invalid nbf claim")
+		}
+		if now.Add(j.Leeway).Before(nbf) {
+			return errors.New("token is not valid yet")
+		}
+	}
+	if j.Audience != "" {
+		found := false
+		for _, aud := range stringList(claims["aud"]) {
+			if aud == j.Audience {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("token is not intended for %q", j.Audience)
+		}
+	}
+	return nil
+}
+
+func verifySignature(key verificationKey, signed string, sig []byte) bool {
+	digest := sha256.Sum256([]byte(signed))
+	switch key.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.key.([]byte))
+		mac.Write([]byte(signed))
+		return hmac.Equal(sig, mac.Sum(nil))
+	case "RS256":
+		return rsa.VerifyPKCS1v15(key.key.(*rsa.PublicKey), crypto.SHA256, digest[:], sig) == nil
+	case "ES256":
+		// JWS encodes ECDSA signatures as the concatenation of r and s
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(key.key.(*ecdsa.PublicKey), digest[:], r, s)
+	default:
+		return false
+	}
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func numericDate(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true
+}
+
+// stringList reads a claim holding either a string or a list of strings
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var res []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// testKeys are the private keys matching the JWKS of newTestJWT
+type testKeys struct {
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+	secret []byte
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// newTestJWT returns a verifier trusting an RSA key (kid "rsa"), a P-256 key
+// (kid "ec") and an HMAC secret (kid "hmac"), with the current time fixed
+func newTestJWT(t *testing.T, now time.Time) (*JWT, testKeys) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "n": %q, "e": %q},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": %q, "y": %q},
+		{"kty": "oct", "kid": "hmac", "k": %q}
+	]}`,
+		b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+		b64(ecKey.X.FillBytes(make([]byte, 32))), b64(ecKey.Y.FillBytes(make([]byte, 32))),
+		b64(secret))
+	keys, err := ParseJWKS([]byte(jwks))
+	if err != nil {
+		t.Fatalf("ParseJWKS: %v", err)
+	}
+	return &JWT{Keys: keys, Audience: "greet", Now: func() time.Time { return now }}, testKeys{rsaKey, ecKey, secret}
+}
+
+// signToken returns a token with the given header and claims, signed by sign
+func signToken(t *testing.T, header, claims map[string]interface{}, sign func(signed string) []byte) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := b64(h) + "." + b64(c)
+	return signed + "." + b64(sign(signed))
+}
+
+func signRS256(key *rsa.PrivateKey) func(string) []byte {
+	return func(signed string) []byte {
+		digest := sha256.Sum256([]byte(signed))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			panic(err)
+		}
+		return sig
+	}
+}
+
+func signES256(key *ecdsa.PrivateKey) func(string) []byte {
+	return func(signed string) []byte {
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			panic(err)
+		}
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+}
+
+func signHS256(secret []byte) func(string) []byte {
+	return func(signed string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		return mac.Sum(nil)
+	}
+}
+
+func TestJWTVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	j, keys := newTestJWT(t, now)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The public key of kid "rsa" as an HMAC secret, which an attacker
+	// knows and would use to forge HS256 tokens if alg were trusted
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&keys.rsa.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{"sub": "alice", "aud": "greet", "exp": now.Add(time.Hour).Unix()}
+	}
+	without := func(claim string) map[string]interface{} {
+		c := valid()
+		delete(c, claim)
+		return c
+	}
+	with := func(claim string, v interface{}) map[string]interface{} {
+		c := valid()
+		c[claim] = v
+		return c
+	}
+	rs256 := map[string]interface{}{"alg": "RS256", "kid": "rsa"}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"valid RS256", signToken(t, rs256, valid(), signRS256(keys.rsa)), ""},
+		{"valid ES256", signToken(t, map[string]interface{}{"alg": "ES256", "kid": "ec"}, valid(), signES256(keys.ec)), ""},
+		{"valid HS256", signToken(t, map[string]interface{}{"alg": "HS256", "kid": "hmac"}, valid(), signHS256(keys.secret)), ""},
+		{"valid without kid", signToken(t, map[string]interface{}{"alg": "RS256"}, valid(), signRS256(keys.rsa)), ""},
+		{"expired", signToken(t, rs256, with("exp", now.Add(-time.Minute).Unix()), signRS256(keys.rsa)), "expired"},
+		{"missing exp", signToken(t, rs256, without("exp"), signRS256(keys.rsa)), "no expiration"},
+		{"exp not a number", signToken(t, rs256, with("exp", "tomorrow"), signRS256(keys.rsa)), "no expiration"},
+		{"not valid yet", signToken(t, rs256, with("nbf", now.Add(time.Minute).Unix()), signRS256(keys.rsa)), "not valid yet"},
+		{"wrong audience", signToken(t, rs256, with("aud", "blog"), signRS256(keys.rsa)), "not intended"},
+		{"wrong alg for kid", signToken(t, map[string]interface{}{"alg": "ES256", "kid": "rsa"}, valid(), signES256(keys.ec)), "no key"},
+		{"HS256 with an RSA key", signToken(t, map[string]interface{}{"alg": "HS256", "kid": "rsa"}, valid(), signHS256(rsaPublic)), "no key"},
+		{"none alg", signToken(t, map[string]interface{}{"alg": "none", "kid": "rsa"}, valid(), func(string) []byte { return nil }), "no key"},
+		{"unknown kid", signToken(t, map[string]interface{}{"alg": "RS256", "kid": "other"}, valid(), signRS256(keys.rsa)), "no key"},
+		{"bad signature", signToken(t, rs256, valid(), signRS256(otherKey)), "invalid token signature"},
+		{"tampered claims", func() string {
+			parts := strings.Split(signToken(t, rs256, valid(), signRS256(keys.rsa)), ".")
+			c, _ := json.Marshal(with("sub", "admin"))
+			return parts[0] + "." + b64(c) + "." + parts[2]
+		}(), "invalid token signature"},
+		{"malformed", "not.a-token", "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := j.Verify(tt.token)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				if claims["sub"] != "alice" {
+					t.Errorf("sub = %v, want alice", claims["sub"])
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Verify succeeded, want an error containing %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Verify: %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTLeeway(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	j, keys := newTestJWT(t, now)
+	token := signToken(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"},
+		map[string]interface{}{"sub": "alice", "aud": "greet", "exp": now.Add(-30 * time.Second).Unix()},
+		signRS256(keys.rsa))
+	if _, err := j.Verify(token); err == nil {
+		t.Fatal("Verify accepted a token expired 30s ago without leeway")
+	}
+	j.Leeway = time.Minute
+	if _, err := j.Verify(token); err != nil {
+		t.Fatalf("Verify with a minute of leeway: %v", err)
+	}
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	j, keys := newTestJWT(t, now)
+	header := map[string]interface{}{"alg": "RS256", "kid": "rsa"}
+	exp := now.Add(time.Hour).Unix()
+	incoming := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationHeader, "Bearer "+token))
+	}
+
+	token := signToken(t, header, map[string]interface{}{"sub": "alice", "aud": "greet", "exp": exp, "roles": []string{"author", "admin"}}, signRS256(keys.rsa))
+	p, err := j.Authenticate(incoming(token), "/blog.BlogService/CreateBlog")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if p.Subject != "alice" || strings.Join(p.Roles, ",") != "author,admin" {
+		t.Errorf("principal = %v %v, want alice [author admin]", p.Subject, p.Roles)
+	}
+
+	token = signToken(t, header, map[string]interface{}{"aud": "greet", "exp": exp}, signRS256(keys.rsa))
+	if _, err := j.Authenticate(incoming(token), "/blog.BlogService/CreateBlog"); err == nil {
+		t.Error("Authenticate accepted a token without subject")
+	}
+
+	if _, err := j.Authenticate(context.Background(), "/blog.BlogService/CreateBlog"); err != ErrNoCredentials {
+		t.Errorf("Authenticate without token: %v, want %v", err, ErrNoCredentials)
+	}
+}
+
+func TestParseJWKSRejectsInvalidKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		jwks string
+	}{
+		{"alg not matching the key type", `{"keys": [{"kty": "oct", "kid": "k", "alg": "RS256", "k": "` + b64(make([]byte, 32)) + `"}]}`},
+		{"short HMAC secret", `{"keys": [{"kty": "oct", "kid": "k", "k": "` + b64(make([]byte, 16)) + `"}]}`},
+		{"small RSA key", `{"keys": [{"kty": "RSA", "kid": "k", "n": "` + b64(make([]byte, 128)) + `", "e": "AQAB"}]}`},
+		{"unsupported curve", `{"keys": [{"kty": "EC", "kid": "k", "crv": "P-384", "x": "AQ", "y": "AQ"}]}`},
+		{"duplicate kid", `{"keys": [{"kty": "oct", "kid": "k", "k": "` + b64(make([]byte, 32)) + `"}, {"kty": "oct", "kid": "k", "k": "` + b64(make([]byte, 32)) + `"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseJWKS([]byte(tt.jwks)); err == nil {
+				t.Error("ParseJWKS accepted the key set")
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// PeerCertificate identifies callers by the client certificate they
+// presented over mutual TLS. The subject is the first URI SAN of the
+// certificate, such as a SPIFFE ID, or else its common name, and the roles
+// are its organizational units.
+type PeerCertificate struct{}
+
+func (PeerCertificate) Authenticate(ctx context.Context, fullMethod string) (*Principal, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	cert := tlsInfo.State.VerifiedChains[0][0]
+	subject := cert.Subject.CommonName
+	if len(cert.URIs) > 0 {
+		subject = cert.URIs[0].String()
+	}
+	if subject == "" {
+		return nil, errors.New("client certificate has no identity")
+	}
+	return &Principal{Subject: subject, Roles: cert.Subject.OrganizationalUnit}, nil
+}
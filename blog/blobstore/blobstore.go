@@ -0,0 +1,29 @@
+// Package blobstore keeps the binary content of blog attachments.
+//
+// Blobs are addressed by a key chosen by the caller; the blog server uses the
+// hex encoded SHA-256 of the content so that identical uploads are stored once.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when no blob is stored under the requested key
+var ErrNotFound = errors.New("blobstore: blob not found")
+
+// ErrInvalidKey is returned for keys which cannot be stored safely
+var ErrInvalidKey = errors.New("blobstore: invalid key")
+
+// Store is implemented by every blob backend
+type Store interface {
+	// Put stores the content of r under key, replacing any previous blob
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns a reader on the blob, the caller must close it
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Exists reports whether a blob is stored under key
+	Exists(ctx context.Context, key string) (bool, error)
+	// Delete removes the blob, deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
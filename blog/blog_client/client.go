@@ -2,19 +2,85 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"greet/auth"
 	"greet/blog/blogpb"
+	"greet/grpcclient"
+	"greet/logging"
+	"greet/tlsutil"
+	"greet/tracing"
 	"io"
 	"log"
+	"os"
+	"time"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
 
+	addr := flag.String("addr", "localhost:50052", "Address of the blog server")
+	serverName := flag.String("server-name", "", "Name expected in the server certificate, defaults to the host of -addr")
+	caFile := flag.String("ca", "", "CA bundle trusted to verify the server, empty to connect in plaintext")
+	certFile := flag.String("cert", "", "Client certificate presented to servers requiring mutual TLS")
+	keyFile := flag.String("key", "", "Private key of the client certificate")
+	traceExporter := flag.String("trace", "none", "Exporter of the call traces: none, stdout or otlp")
+	traceEndpoint := flag.String("trace-endpoint", "", "OTLP/HTTP traces endpoint, defaults to a local collector")
+	flag.Parse()
+
+	tracer, err := tracing.Setup(*traceExporter, *traceEndpoint, tracing.Options{ServiceName: "blog-client", SampleRatio: 1})
+	if err != nil {
+		log.Fatalf("Error while setting up tracing : %v", err)
+	}
+	if tracer != nil {
+		defer tracer.Shutdown(context.Background())
+	}
+
 	fmt.Println("Blog Client")
-	opts := grpc.WithInsecure()
-	conn, err := grpc.Dial("localhost:50051", opts)
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+
+	// Client certificates are only presented over TLS
+	if (*certFile != "" || *keyFile != "") && *caFile == "" {
+		log.Fatalf("-cert and -key need -ca : client certificates are only presented over TLS")
+	}
+
+	// With SSL
+	tls := *caFile != ""
+	if tls {
+		certs, err := tlsutil.NewReloader(*certFile, *keyFile, *caFile)
+		if err != nil {
+			log.Fatalf("Error while loading certificates : %v", err)
+		}
+		defer certs.Watch(10 * time.Second).Close()
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(certs.ClientConfig(*serverName)))}
+	}
+
+	// Authenticate with an API key or a bearer token when one is provided
+	apiKey := os.Getenv("BLOG_API_KEY")
+	if apiKey != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(auth.APIKey{Key: apiKey, AllowInsecure: !tls}))
+	}
+	token := os.Getenv("BLOG_TOKEN")
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(auth.BearerToken{Token: token, AllowInsecure: !tls}))
+	}
+
+	// Blogs are written by the authenticated caller
+	if apiKey == "" && token == "" && *certFile == "" {
+		log.Fatalf("No credentials : set BLOG_API_KEY or BLOG_TOKEN, or pass -cert and -key")
+	}
+
+	// Send the trace context and a request ID with every call, retried or
+	// hedged by the client policy
+	opts = append(opts,
+		grpc.WithChainUnaryInterceptor(otelgrpc.UnaryClientInterceptor(), logging.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(otelgrpc.StreamClientInterceptor(), logging.StreamClientInterceptor()),
+	)
+
+	conn, err := grpcclient.Dial(*addr, grpcclient.BlogPolicy(), opts...)
 	if err != nil {
 		log.Fatalf("Could not connect : %v", err)
 	}
@@ -22,14 +88,15 @@ func main() {
 
 	c := blogpb.NewBlogServiceClient(conn)
 
+	ctx := context.Background()
+
 	// 1. Create Blog
 	fmt.Println("Create a Blog")
 	blog := &blogpb.Blog{
-		AuthorId: "Deepak",
-		Title:    "My First Blog",
-		Content:  "Content of the first Blog",
+		Title:   "My First Blog",
+		Content: "Content of the first Blog",
 	}
-	createBlogRes, err := c.CreateBlog(context.Background(), &blogpb.CreateBlogRequest{Blog: blog})
+	createBlogRes, err := c.CreateBlog(ctx, &blogpb.CreateBlogRequest{Blog: blog})
 	if err != nil {
 		log.Fatalf("Unexpected Error : %v", err)
 	}
@@ -38,40 +105,99 @@ func main() {
 
 	// 2. Read Blog
 	fmt.Println("Reading Blog")
-	_, readErr := c.ReadBlog(context.Background(), &blogpb.ReadBlogRequest{BlogId: "60eaf2ca7626531bf703318a"})
+	_, readErr := c.ReadBlog(ctx, &blogpb.ReadBlogRequest{BlogId: "60eaf2ca7626531bf703318a"})
 	if readErr != nil {
 		fmt.Printf("Error happened while reading : %v \n", readErr)
 	}
 	readBlogReq := &blogpb.ReadBlogRequest{BlogId: blogID}
-	readBlogRes, readBlogErr := c.ReadBlog(context.Background(), readBlogReq)
+	readBlogRes, readBlogErr := c.ReadBlog(ctx, readBlogReq)
 	if readBlogErr != nil {
 		fmt.Printf("Error happened while reading : %v \n", err)
 	}
 	fmt.Printf("Blog was read : %v \n", readBlogRes)
+	viewRes, viewErr := c.RecordView(ctx, &blogpb.RecordViewRequest{BlogId: blogID})
+	if viewErr != nil {
+		fmt.Printf("Error happened while recording view : %v \n", viewErr)
+	}
+	fmt.Printf("Blog was viewed : %v \n", viewRes)
+	likeRes, likeErr := c.LikeBlog(ctx, &blogpb.LikeBlogRequest{BlogId: blogID})
+	if likeErr != nil {
+		fmt.Printf("Error happened while liking : %v \n", likeErr)
+	}
+	fmt.Printf("Blog was liked : %v \n", likeRes)
 
 	// 3. Update Blog
 	fmt.Println("Update a Blog")
 	updateBlog := &blogpb.Blog{
-		Id:       blogID,
-		AuthorId: "Deepak Mahana",
-		Title:    "My Updated Blog",
-		Content:  "Content of the updated Blog",
+		Id:      blogID,
+		Title:   "My Updated Blog",
+		Content: "Content of the updated Blog",
 	}
-	updateBlogRes, updateErr := c.UpdateBlog(context.Background(), &blogpb.UpdateBlogRequest{Blog: updateBlog})
+	updateBlogRes, updateErr := c.UpdateBlog(ctx, &blogpb.UpdateBlogRequest{Blog: updateBlog})
 	if updateErr != nil {
 		log.Fatalf("Unexpected Error : %v \n", updateErr)
 	}
 	fmt.Printf("Blog has been updated %v \n", updateBlogRes)
+	relatedRes, relatedErr := c.ListRelatedBlogs(ctx, &blogpb.ListRelatedBlogsRequest{BlogId: blogID, Limit: 3})
+	if relatedErr != nil {
+		fmt.Printf("Error happened while listing related blogs : %v \n", relatedErr)
+	}
+	for _, related := range relatedRes.GetBlogs() {
+		fmt.Printf("Related blog (score %.2f) : %v \n", related.GetScore(), related.GetBlog())
+	}
+
+	// 4. Upload and download an attachment
+	uploadStream, err := c.UploadAttachment(ctx)
+	if err != nil {
+		log.Fatalf("Error while calling UploadAttachment RPC : %v", err)
+	}
+	uploadStream.Send(&blogpb.UploadAttachmentRequest{
+		Data: &blogpb.UploadAttachmentRequest_Info{Info: &blogpb.Attachment{
+			BlogId:   blogID,
+			Filename: "notes.txt",
+		}},
+	})
+	for _, chunk := range []string{"Attachment ", "of the ", "updated Blog"} {
+		uploadStream.Send(&blogpb.UploadAttachmentRequest{
+			Data: &blogpb.UploadAttachmentRequest_Chunk{Chunk: []byte(chunk)},
+		})
+	}
+	uploadRes, uploadErr := uploadStream.CloseAndRecv()
+	if uploadErr != nil {
+		log.Fatalf("Unexpected Error : %v \n", uploadErr)
+	}
+	fmt.Printf("Attachment has been uploaded %v \n", uploadRes)
 
-	// 4. Delete Blog
-	deleteRes, deleteErr := c.DeleteBlog(context.Background(), &blogpb.DeleteBlogRequest{BlogId: blogID})
+	downloadStream, err := c.DownloadAttachment(ctx, &blogpb.DownloadAttachmentRequest{
+		AttachmentId: uploadRes.GetAttachment().GetId(),
+	})
+	if err != nil {
+		log.Fatalf("Error while calling DownloadAttachment RPC : %v", err)
+	}
+	for {
+		res, err := downloadStream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Something happened: %v", err)
+		}
+		if info := res.GetInfo(); info != nil {
+			fmt.Printf("Downloading attachment %v \n", info)
+		} else {
+			fmt.Printf("Received chunk : %s \n", res.GetChunk())
+		}
+	}
+
+	// 5. Delete Blog
+	deleteRes, deleteErr := c.DeleteBlog(ctx, &blogpb.DeleteBlogRequest{BlogId: blogID})
 	if deleteErr != nil {
 		fmt.Printf("Error happened while deleting : %v \n", updateErr)
 	}
 	fmt.Printf("Blog was deleted: %v \n", deleteRes)
 
-	// 5. List Blogs
-	stream, err := c.ListBlog(context.Background(), &blogpb.ListBlogRequest{})
+	// 6. List Blogs
+	stream, err := c.ListBlog(ctx, &blogpb.ListBlogRequest{})
 	if err != nil {
 		log.Fatalf("Error while calling ListBlog RPC : %v", err)
 	}
@@ -86,4 +212,21 @@ func main() {
 		fmt.Println(res.GetBlog())
 	}
 
+	// 7. Get the RSS feed of an author
+	feedRes, feedErr := c.GetFeed(ctx, &blogpb.GetFeedRequest{
+		Format:   blogpb.GetFeedRequest_RSS,
+		AuthorId: "Deepak",
+	})
+	if feedErr != nil {
+		log.Fatalf("Error while calling GetFeed RPC : %v", feedErr)
+	}
+	fmt.Println(string(feedRes.GetData()))
+
+	// 8. Blog statistics
+	statsRes, statsErr := c.GetBlogStats(ctx, &blogpb.GetBlogStatsRequest{})
+	if statsErr != nil {
+		log.Fatalf("Error while calling GetBlogStats RPC : %v", statsErr)
+	}
+	fmt.Printf("Blog statistics : %v \n", statsRes)
+
 }
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"greet/blog/blogservice"
+	"greet/config"
+)
+
+// serverConfig holds the settings of the blog server, read from the file
+// named by -config or BLOG_CONFIG, BLOG_* environment variables and flags
+type serverConfig struct {
+	Server config.Server      `yaml:",inline"`
+	Blog   blogservice.Config `yaml:",inline"`
+}
+
+func defaultConfig() *serverConfig {
+	cfg := &serverConfig{
+		Server: config.DefaultServer(":50052"),
+		Blog:   blogservice.DefaultConfig(),
+	}
+	cfg.Server.MetricsAddr = "0.0.0.0:9092"
+	cfg.Server.Tracing.ServiceName = "blog-server"
+	return cfg
+}
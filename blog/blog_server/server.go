@@ -3,256 +3,65 @@ package main
 import (
 	"context"
 	"fmt"
-	"greet/blog/blogpb"
-	"log"
-	"net"
+	"greet/blog/blogservice"
+	"greet/config"
+	"greet/grpcserver"
+	"greet/logging"
 	"os"
-	"os/signal"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/reflection"
-	"google.golang.org/grpc/status"
+	"time"
 )
 
-var collection *mongo.Collection
-
-type server struct {
-}
-
-type blogItem struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	AuthorID string             `bson:"author_id"`
-	Content  string             `bson:"content"`
-	Title    string             `bson:"title"`
-}
-
-/** Create Blog **/
-func (*server) CreateBlog(ctx context.Context, req *blogpb.CreateBlogRequest) (*blogpb.CreateBlogResponse, error) {
-
-	blog := req.GetBlog()
-	data := blogItem{
-		AuthorID: blog.GetAuthorId(),
-		Title:    blog.GetTitle(),
-		Content:  blog.GetContent(),
-	}
-	res, err := collection.InsertOne(context.Background(), data)
-	if err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("Internal Error : %v", err),
-		)
-	}
-	oid, ok := res.InsertedID.(primitive.ObjectID)
-	if !ok {
-		return nil, status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("Cannot convert to OID : %v", oid),
-		)
-	}
-
-	return &blogpb.CreateBlogResponse{
-		Blog: &blogpb.Blog{
-			Id:       oid.Hex(),
-			AuthorId: blog.GetAuthorId(),
-			Title:    blog.GetTitle(),
-			Content:  blog.GetContent(),
-		},
-	}, nil
-
-}
-
-/** Read Blog **/
-func (*server) ReadBlog(ctx context.Context, req *blogpb.ReadBlogRequest) (*blogpb.ReadBlogResponse, error) {
-
-	fmt.Println("Read blog request")
-	blogID := req.GetBlogId()
-	oid, err := primitive.ObjectIDFromHex(blogID)
-	if err != nil {
-		return nil, status.Errorf(
-			codes.InvalidArgument,
-			fmt.Sprintf("Cannot Parse ID"),
-		)
-	}
-	// Create an empty struct to store read result
-	data := &blogItem{}
-	filter := bson.M{"_id": oid}
-	res := collection.FindOne(context.Background(), filter)
-	if err := res.Decode(data); err != nil {
-		return nil, status.Error(
-			codes.NotFound,
-			fmt.Sprintf("Cannot find the blog with ID : %v", err),
-		)
-	}
-	return &blogpb.ReadBlogResponse{
-		Blog: dataToBlobPb(data),
-	}, nil
-}
-
-func dataToBlobPb(data *blogItem) *blogpb.Blog {
-	return &blogpb.Blog{
-		Id:       data.ID.Hex(),
-		AuthorId: data.AuthorID,
-		Content:  data.Content,
-		Title:    data.Title,
-	}
-}
-
-/** Update Blog **/
-func (*server) UpdateBlog(ctx context.Context, req *blogpb.UpdateBlogRequest) (*blogpb.UpdateBlogResponse, error) {
-	fmt.Println("update Blog Request")
-	blog := req.GetBlog()
-	oid, err := primitive.ObjectIDFromHex(blog.GetId())
-	if err != nil {
-		return nil, status.Error(
-			codes.InvalidArgument,
-			fmt.Sprintf("Cannot parse ID"),
-		)
-	}
-	// Create an empty struct and find document
-	data := &blogItem{}
-	filter := bson.M{"_id": oid}
-	res := collection.FindOne(context.Background(), filter)
-	if err := res.Decode(data); err != nil {
-		return nil, status.Error(
-			codes.NotFound,
-			fmt.Sprintf("Cannot find blog with specified ID : %v", err),
-		)
-	}
-	// Update Internal Struct
-	data.AuthorID = blog.GetAuthorId()
-	data.Content = blog.GetContent()
-	data.Title = blog.GetTitle()
-	_, updateErr := collection.ReplaceOne(context.Background(), filter, data)
-	if updateErr != nil {
-		return nil, status.Error(
-			codes.Internal,
-			fmt.Sprintf("Cannot update object in MongoDB : %v", updateErr),
-		)
-	}
-	return &blogpb.UpdateBlogResponse{
-		Blog: dataToBlobPb(data),
-	}, nil
-}
-
-/** Delete Blog **/
-func (*server) DeleteBlog(ctx context.Context, req *blogpb.DeleteBlogRequest) (*blogpb.DeleteBlogResponse, error) {
-	fmt.Println("Delete Blog Request")
-	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
-	if err != nil {
-		return nil, status.Errorf(
-			codes.InvalidArgument,
-			fmt.Sprintf("Cannot parse ID"),
-		)
-	}
-	filter := bson.M{"_id": oid}
-	res, err := collection.DeleteOne(context.Background(), filter)
-	if err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("Cannot Delete object in MongoDB: %v", err),
-		)
-	}
-	if res.DeletedCount == 0 {
-		return nil, status.Errorf(
-			codes.NotFound,
-			fmt.Sprintf("Cannot find blog in MongoDB: %v", err),
-		)
-	}
-	return &blogpb.DeleteBlogResponse{BlogId: req.GetBlogId()}, nil
-}
+/** Server Main Func **/
+func main() {
 
-/** List Blogs **/
-func (*server) ListBlog(req *blogpb.ListBlogRequest, stream blogpb.BlogService_ListBlogServer) error {
-	fmt.Println("List blog request")
-	cur, err := collection.Find(context.Background(), bson.D{{}})
-	if err != nil {
-		return status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("Unknown Internal Error : %v", err),
-		)
-	}
-	defer cur.Close(context.Background())
-	for cur.Next(context.Background()) {
-		data := &blogItem{}
-		err := cur.Decode(data)
-		if err != nil {
-			return status.Errorf(
-				codes.Internal,
-				fmt.Sprintf("Error while decoding data from MongoDB: %v", err),
-			)
-		}
-		stream.Send(&blogpb.ListBlogResponse{Blog: dataToBlobPb(data)})
+	cfg := defaultConfig()
+	if err := config.Load(cfg, "BLOG", os.Args[1:]); err != nil {
+		logging.Fatal("Invalid configuration", logging.Err(err))
 	}
-	if err := cur.Err(); err != nil {
-		return status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("Unknown internal error : %v", err),
-		)
+	if err := logging.Setup(cfg.Server.Log.Level, cfg.Server.Log.Format, cfg.Server.Log.Redact); err != nil {
+		logging.Fatal("Invalid log configuration", logging.Err(err))
 	}
-	return nil
-}
 
-/** Server Main Func **/
-func main() {
+	fmt.Println("Effective configuration")
+	config.Fprint(os.Stdout, cfg)
 
-	// If app crashes will receive filename and line number
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-
-	// Mongo Connection
-	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = client.Connect(context.TODO())
+	blog, err := blogservice.New(context.TODO(), cfg.Blog)
 	if err != nil {
-		log.Fatal(err)
-	} else {
-		fmt.Println("MongoDB connected")
+		logging.Fatal("Failed to start the blog service", logging.Err(err))
 	}
-	collection = client.Database("mydb").Collection("blog")
 
 	// Grpc Server Connection
-	lis, err := net.Listen("tcp", "0.0.0.0:50051")
+	s, err := grpcserver.New(cfg.Server)
 	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+		logging.Fatal("Failed to start", logging.Err(err))
 	}
+	blog.Register(s.GRPC)
+	s.Probe(blogservice.ServiceName, cfg.Blog.Mongo.PingInterval, blog.Ping)
 
-	opts := []grpc.ServerOption{} // If not set then bydefault it will be nil
-	s := grpc.NewServer(opts...)
-	blogpb.RegisterBlogServiceServer(s, &server{})
-
-	// Register reflection service on gRPC server for evans CLI
-	reflection.Register(s)
-
 	go func() {
-		fmt.Println("Blog Service Started")
-		if err := s.Serve(lis); err != nil {
-			log.Fatalf("Failed to serve: %v", err)
+		logging.Info("Blog service started", logging.F("addr", s.Addr()))
+		if err := s.Serve(); err != nil {
+			logging.Fatal("Failed to serve", logging.Err(err))
+		}
+	}()
+	go func() {
+		if err := blog.ServeFeeds(); err != nil {
+			logging.Fatal("Failed to serve feeds", logging.Err(err))
 		}
 	}()
 
 	// Graceful Shutdown
 
-	// Wait for Control C to exit
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt)
+	// Block until Control C or SIGTERM, then drain the calls in flight
+	// before closing the connection with MongoDB they may still be using
+	logging.Info("Stopping the server", logging.F("signal", grpcserver.WaitForSignal()))
+	s.Shutdown(cfg.Server.ShutdownTimeout)
 
-	// Block Until a signal is received
-	<-ch
-	// First we close the connection with MongoDB:
-	fmt.Println("Closing MongoDB Connection")
-	if err := client.Disconnect(context.TODO()); err != nil {
-		log.Fatalf("Error on disconnection with MongoDB : %v", err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := blog.Close(ctx); err != nil {
+		logging.Fatal("Error on disconnection with MongoDB", logging.Err(err))
 	}
-
-	// Finally, we stop the server
-	fmt.Println("Stopping the server")
-	s.Stop()
-	fmt.Println("End of Program")
+	logging.Info("End of Program")
 
 }
@@ -0,0 +1,293 @@
+package blogservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"greet/auth"
+	"greet/blog/blobstore"
+	"greet/blog/blogpb"
+	"greet/grpcserver"
+	"greet/logging"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Size of the data chunks sent by DownloadAttachment
+const attachmentChunkSize = 64 * 1024
+
+var attachments *mongo.Collection
+
+// attachmentItem is the metadata of an attachment, its content lives in the
+// blob store under its SHA-256 and may be shared by several attachments
+type attachmentItem struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	BlogID      primitive.ObjectID `bson:"blog_id"`
+	Filename    string             `bson:"filename"`
+	ContentType string             `bson:"content_type"`
+	Size        int64              `bson:"size"`
+	SHA256      string             `bson:"sha256"`
+}
+
+func dataToAttachmentPb(data *attachmentItem) *blogpb.Attachment {
+	return &blogpb.Attachment{
+		Id:          data.ID.Hex(),
+		BlogId:      data.BlogID.Hex(),
+		Filename:    data.Filename,
+		ContentType: data.ContentType,
+		Size:        data.Size,
+		Sha256:      data.SHA256,
+	}
+}
+
+/** Upload Attachment **/
+func (s *Server) UploadAttachment(stream blogpb.BlogService_UploadAttachmentServer) error {
+	ctx := stream.Context()
+	caller, err := auth.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := stream.Recv()
+	if err != nil {
+		return grpcserver.StreamError(err, "Cannot receive attachment info")
+	}
+	info := req.GetInfo()
+	if info == nil {
+		return status.Errorf(codes.InvalidArgument, "The first message must carry the attachment info")
+	}
+	blogID, err := primitive.ObjectIDFromHex(info.GetBlogId())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "Cannot parse blog ID")
+	}
+	// Only the author of the blog can attach files to it
+	count, err := collection.CountDocuments(ctx, ownedBy(caller, blogID))
+	if err != nil {
+		return status.Errorf(codes.Internal, "Internal Error : %v", err)
+	}
+	if count == 0 {
+		return notOwnedError(ctx, blogID)
+	}
+	filename := filepath.Base(info.GetFilename())
+	if filename == "." || filename == string(filepath.Separator) {
+		return status.Errorf(codes.InvalidArgument, "Missing attachment filename")
+	}
+
+	// Spool the content to a temporary file while hashing it, the hash is
+	// only known once the last chunk is received
+	tmp, err := os.CreateTemp("", "attachment-*")
+	if err != nil {
+		return status.Errorf(codes.Internal, "Cannot create temporary file : %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	w := io.MultiWriter(tmp, hash)
+	var size int64
+	var head []byte
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return grpcserver.StreamError(err, "Error while reading client stream")
+		}
+		chunk := req.GetChunk()
+		size += int64(len(chunk))
+		if size > s.maxAttachmentSize {
+			return status.Errorf(codes.InvalidArgument, "Attachment exceeds the maximum size of %v bytes", s.maxAttachmentSize)
+		}
+		// Keep the first bytes to sniff the content type
+		if n := 512 - len(head); n > 0 {
+			if n > len(chunk) {
+				n = len(chunk)
+			}
+			head = append(head, chunk[:n]...)
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return status.Errorf(codes.Internal, "Cannot write temporary file : %v", err)
+		}
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	contentType := info.GetContentType()
+	if contentType == "" {
+		contentType = http.DetectContentType(head)
+	}
+	data := &attachmentItem{
+		BlogID:      blogID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      sum,
+	}
+
+	// Identical content is only stored once. The blob cannot be deleted by
+	// deleteAttachments until the attachment referring to it is inserted.
+	unlock := s.blobLocks.lock(sum)
+	defer unlock()
+	exists, err := s.blobs.Exists(ctx, sum)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Cannot access blob store : %v", err)
+	}
+	if !exists {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return status.Errorf(codes.Internal, "Cannot read temporary file : %v", err)
+		}
+		if err := s.blobs.Put(ctx, sum, tmp); err != nil {
+			return status.Errorf(codes.Internal, "Cannot store attachment : %v", err)
+		}
+	}
+	res, err := attachments.InsertOne(ctx, data)
+	if err != nil {
+		// Do not leave behind content nothing refers to, even when the
+		// insert failed because the caller went away
+		if !exists {
+			if delErr := s.blobs.Delete(context.Background(), sum); delErr != nil {
+				logging.FromContext(ctx).Warn("Cannot delete orphaned attachment content", logging.F("sha256", sum), logging.Err(delErr))
+			}
+		}
+		return status.Errorf(codes.Internal, "Internal Error : %v", err)
+	}
+	unlock()
+	oid, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return status.Errorf(codes.Internal, "Cannot convert to OID : %v", oid)
+	}
+	data.ID = oid
+	return stream.SendAndClose(&blogpb.UploadAttachmentResponse{
+		Attachment: dataToAttachmentPb(data),
+	})
+}
+
+/** Download Attachment **/
+func (s *Server) DownloadAttachment(req *blogpb.DownloadAttachmentRequest, stream blogpb.BlogService_DownloadAttachmentServer) error {
+	ctx := stream.Context()
+	oid, err := primitive.ObjectIDFromHex(req.GetAttachmentId())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "Cannot parse ID")
+	}
+	data := &attachmentItem{}
+	if err := attachments.FindOne(ctx, bson.M{"_id": oid}).Decode(data); err != nil {
+		return status.Errorf(codes.NotFound, "Cannot find attachment with specified ID : %v", err)
+	}
+	blob, err := s.blobs.Open(ctx, data.SHA256)
+	if err == blobstore.ErrNotFound {
+		return status.Errorf(codes.NotFound, "Attachment content is missing from the blob store")
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "Cannot open attachment : %v", err)
+	}
+	defer blob.Close()
+
+	if err := stream.Send(&blogpb.DownloadAttachmentResponse{
+		Data: &blogpb.DownloadAttachmentResponse_Info{Info: dataToAttachmentPb(data)},
+	}); err != nil {
+		return err
+	}
+	buf := make([]byte, attachmentChunkSize)
+	for {
+		n, err := io.ReadFull(blob, buf)
+		if n > 0 {
+			sendErr := stream.Send(&blogpb.DownloadAttachmentResponse{
+				Data: &blogpb.DownloadAttachmentResponse_Chunk{Chunk: buf[:n]},
+			})
+			if sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "Cannot read attachment : %v", err)
+		}
+	}
+}
+
+// deleteUnreferencedBlob deletes the content sum once no attachment refers
+// to it
+func (s *Server) deleteUnreferencedBlob(ctx context.Context, sum string) error {
+	unlock := s.blobLocks.lock(sum)
+	defer unlock()
+	count, err := attachments.CountDocuments(ctx, bson.M{"sha256": sum})
+	if err != nil || count > 0 {
+		return err
+	}
+	return s.blobs.Delete(ctx, sum)
+}
+
+// deleteAttachments removes the attachments of a blog, and their content
+// once no other attachment refers to it
+func (s *Server) deleteAttachments(ctx context.Context, blogID primitive.ObjectID) error {
+	filter := bson.M{"blog_id": blogID}
+	hashes, err := attachments.Distinct(ctx, "sha256", filter)
+	if err != nil {
+		return err
+	}
+	if _, err := attachments.DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		sum, ok := h.(string)
+		if !ok {
+			continue
+		}
+		if err := s.deleteUnreferencedBlob(ctx, sum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyedMutex locks keys independently of each other
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// lock locks key and returns the function unlocking it, which may be
+// called several times
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.waiters++
+	k.mu.Unlock()
+
+	l.Lock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.Unlock()
+			k.mu.Lock()
+			l.waiters--
+			if l.waiters == 0 {
+				delete(k.locks, key)
+			}
+			k.mu.Unlock()
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package blogservice
+
+import (
+	"context"
+	"fmt"
+	"greet/auth"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ownedBy restricts the filter of a mutation to the blogs p may modify:
+// its own blogs, or any blog for admins
+func ownedBy(p *auth.Principal, oid primitive.ObjectID) bson.M {
+	filter := bson.M{"_id": oid}
+	if !p.IsAdmin() {
+		filter["author_id"] = p.Subject
+	}
+	return filter
+}
+
+// notOwnedError is returned once a mutation restricted with ownedBy matched
+// no blog, to tell apart missing blogs from blogs owned by someone else
+func notOwnedError(ctx context.Context, oid primitive.ObjectID) error {
+	count, err := collection.CountDocuments(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return status.Errorf(codes.Internal, fmt.Sprintf("Internal Error : %v", err))
+	}
+	if count == 0 {
+		return status.Errorf(codes.NotFound, fmt.Sprintf("Cannot find blog with specified ID : %v", oid.Hex()))
+	}
+	return status.Errorf(codes.PermissionDenied, "Only the author of the blog can modify it")
+}
@@ -0,0 +1,54 @@
+package blogservice
+
+import (
+	"errors"
+	"time"
+)
+
+// Config holds the settings of the blog service
+type Config struct {
+	Mongo struct {
+		URI      string `yaml:"uri" usage:"MongoDB connection string"`
+		Database string `yaml:"database" usage:"MongoDB database holding the blogs and attachments"`
+		// The service reports NOT_SERVING while MongoDB fails to answer
+		PingInterval time.Duration `yaml:"ping_interval" usage:"Interval between the MongoDB pings of the health check"`
+	} `yaml:"mongo"`
+	Feed struct {
+		Addr string `yaml:"addr" usage:"HTTP address serving the RSS and Atom feeds, empty to disable"`
+		// The feed server only serves the feeds, the pages they link to are
+		// served by the site at BaseURL
+		BaseURL string `yaml:"base_url" usage:"Base URL of the site serving the blog pages at /blogs/<id> and /authors/<id>, which the feeds link to, empty to leave the links out"`
+	} `yaml:"feed"`
+	Attachments struct {
+		Dir     string `yaml:"dir" usage:"Directory of the local attachment blob store"`
+		MaxSize int64  `yaml:"max_size" flag:"max-attachment-size" usage:"Maximum size of an attachment in bytes"`
+	} `yaml:"attachments"`
+}
+
+// DefaultConfig uses the local MongoDB server and attachment directory
+func DefaultConfig() Config {
+	var cfg Config
+	cfg.Mongo.URI = "mongodb://localhost:27017"
+	cfg.Mongo.Database = "mydb"
+	cfg.Mongo.PingInterval = 10 * time.Second
+	cfg.Feed.Addr = "127.0.0.1:8080"
+	cfg.Attachments.Dir = "blog/attachments"
+	cfg.Attachments.MaxSize = 10 << 20
+	return cfg
+}
+
+func (c *Config) Validate() error {
+	if c.Mongo.URI == "" || c.Mongo.Database == "" {
+		return errors.New("mongo.uri and mongo.database are required")
+	}
+	if c.Mongo.PingInterval <= 0 {
+		return errors.New("mongo.ping_interval must be positive")
+	}
+	if c.Attachments.Dir == "" {
+		return errors.New("attachments.dir is required")
+	}
+	if c.Attachments.MaxSize <= 0 {
+		return errors.New("attachments.max_size must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package blogservice
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"greet/blog/blogpb"
+	"greet/logging"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Number of most recent posts published in a feed
+const feedSize = 20
+
+const (
+	rssContentType  = "application/rss+xml; charset=utf-8"
+	atomContentType = "application/atom+xml; charset=utf-8"
+)
+
+// Timeouts of the feed HTTP server, so that slow clients cannot hold its
+// connections open
+const (
+	feedReadHeaderTimeout = 5 * time.Second
+	feedReadTimeout       = 10 * time.Second
+	feedWriteTimeout      = 30 * time.Second
+	feedIdleTimeout       = 60 * time.Second
+)
+
+// RSS 2.0 document
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link,omitempty"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link,omitempty"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// Atom (RFC 4287) document
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Link      *atomLink   `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    atomAuthor  `xml:"author"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// feedGenerator renders the most recent posts as RSS or Atom documents.
+// baseURL is the site serving the blog pages at /blogs/<id> and
+// /authors/<id>, which the feeds and their entries link to. The feeds carry
+// no links without it, as the feed server only serves the feeds.
+type feedGenerator struct {
+	baseURL string
+}
+
+func (g *feedGenerator) channelLink(authorID string) string {
+	if g.baseURL == "" {
+		return ""
+	}
+	if authorID == "" {
+		return g.baseURL + "/blogs"
+	}
+	return g.baseURL + "/authors/" + url.PathEscape(authorID)
+}
+
+func (g *feedGenerator) blogLink(data *blogItem) string {
+	if g.baseURL == "" {
+		return ""
+	}
+	return g.baseURL + "/blogs/" + data.ID.Hex()
+}
+
+func feedTitle(authorID string) string {
+	if authorID == "" {
+		return "Blog"
+	}
+	return "Blog posts by " + authorID
+}
+
+// recentBlogs returns the latest posts, newest first, optionally limited to one author
+func recentBlogs(ctx context.Context, authorID string) ([]*blogItem, error) {
+	filter := bson.M{}
+	if authorID != "" {
+		filter["author_id"] = authorID
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(feedSize)
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var blogs []*blogItem
+	for cur.Next(ctx) {
+		data := &blogItem{}
+		if err := cur.Decode(data); err != nil {
+			return nil, err
+		}
+		blogs = append(blogs, data)
+	}
+	return blogs, cur.Err()
+}
+
+// updatedAt falls back to the creation time held in the ObjectID for posts never updated
+func updatedAt(data *blogItem) time.Time {
+	if data.UpdatedAt.IsZero() {
+		return data.ID.Timestamp()
+	}
+	return data.UpdatedAt
+}
+
+// lastUpdated returns the time the most recently updated post changed, the
+// epoch when there is none. Posts are sorted by creation, so an edited older
+// post may be the last updated.
+func lastUpdated(blogs []*blogItem) time.Time {
+	last := time.Unix(0, 0)
+	for _, data := range blogs {
+		if t := updatedAt(data); t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+func (g *feedGenerator) rss(authorID string, blogs []*blogItem) ([]byte, error) {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       feedTitle(authorID),
+			Link:        g.channelLink(authorID),
+			Description: feedTitle(authorID),
+		},
+	}
+	if len(blogs) > 0 {
+		feed.Channel.LastBuildDate = lastUpdated(blogs).UTC().Format(time.RFC1123Z)
+	}
+	for _, data := range blogs {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       data.Title,
+			Link:        g.blogLink(data),
+			Description: data.Content,
+			GUID:        rssGUID{Value: "urn:blog:" + data.ID.Hex()},
+			PubDate:     data.ID.Timestamp().UTC().Format(time.RFC1123Z),
+		})
+	}
+	return marshalFeed(feed)
+}
+
+func (g *feedGenerator) atom(authorID string, blogs []*blogItem) ([]byte, error) {
+	feedID := "urn:blog:feed"
+	if authorID != "" {
+		feedID = "urn:blog:author:" + authorID
+	}
+	feed := atomFeed{
+		Title:   feedTitle(authorID),
+		ID:      feedID,
+		Updated: lastUpdated(blogs).UTC().Format(time.RFC3339),
+	}
+	if link := g.channelLink(authorID); link != "" {
+		feed.Link = []atomLink{{Href: link}}
+	}
+	for _, data := range blogs {
+		updated := updatedAt(data).UTC().Format(time.RFC3339)
+		entry := atomEntry{
+			Title:     data.Title,
+			ID:        "urn:blog:" + data.ID.Hex(),
+			Published: data.ID.Timestamp().UTC().Format(time.RFC3339),
+			Updated:   updated,
+			Author:    atomAuthor{Name: data.AuthorID},
+			Content:   atomContent{Type: "text", Value: data.Content},
+		}
+		if link := g.blogLink(data); link != "" {
+			entry.Link = &atomLink{Href: link, Rel: "alternate"}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return marshalFeed(feed)
+}
+
+func marshalFeed(v interface{}) ([]byte, error) {
+	out, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// generate builds the feed document and returns it along with its content type
+func (g *feedGenerator) generate(ctx context.Context, format blogpb.GetFeedRequest_Format, authorID string) ([]byte, string, error) {
+	blogs, err := recentBlogs(ctx, authorID)
+	if err != nil {
+		return nil, "", err
+	}
+	switch format {
+	case blogpb.GetFeedRequest_RSS:
+		data, err := g.rss(authorID, blogs)
+		return data, rssContentType, err
+	case blogpb.GetFeedRequest_ATOM:
+		data, err := g.atom(authorID, blogs)
+		return data, atomContentType, err
+	default:
+		return nil, "", fmt.Errorf("unknown feed format %v", format)
+	}
+}
+
+// ServeHTTP serves /feed/rss and /feed/atom, with an optional ?author_id= filter
+func (g *feedGenerator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var format blogpb.GetFeedRequest_Format
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/feed/rss":
+		format = blogpb.GetFeedRequest_RSS
+	case "/feed/atom":
+		format = blogpb.GetFeedRequest_ATOM
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	data, contentType, err := g.generate(r.Context(), format, r.URL.Query().Get("author_id"))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Cannot generate feed", logging.F("path", r.URL.Path), logging.Err(err))
+		http.Error(w, "Cannot generate feed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
+
+/** Get Feed **/
+func (s *Server) GetFeed(ctx context.Context, req *blogpb.GetFeedRequest) (*blogpb.GetFeedResponse, error) {
+	if _, ok := blogpb.GetFeedRequest_Format_name[int32(req.GetFormat())]; !ok {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			fmt.Sprintf("Unknown feed format : %v", req.GetFormat()),
+		)
+	}
+	data, contentType, err := s.feeds.generate(ctx, req.GetFormat(), req.GetAuthorId())
+	if err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Cannot generate feed : %v", err),
+		)
+	}
+	return &blogpb.GetFeedResponse{
+		Data:        data,
+		ContentType: contentType,
+	}, nil
+}
@@ -0,0 +1,57 @@
+package blogservice
+
+import (
+	"context"
+	"fmt"
+	"greet/grpcclient"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// maxIdempotencyKeyLength bounds the idempotency keys accepted from callers
+const maxIdempotencyKeyLength = 128
+
+// createIdempotencyIndex makes the idempotency keys unique per author, so
+// that concurrent attempts of a create cannot both insert a blog
+func createIdempotencyIndex(ctx context.Context) error {
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "author_id", Value: 1}, {Key: "idempotency_key", Value: 1}},
+		Options: options.Index().
+			SetName("author_id_idempotency_key").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"idempotency_key": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+// idempotencyKey returns the idempotency key sent by the caller, if any
+func idempotencyKey(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(grpcclient.IdempotencyKeyHeader)
+	if len(values) == 0 {
+		return "", nil
+	}
+	if len(values) > 1 || values[0] == "" || len(values[0]) > maxIdempotencyKeyLength {
+		return "", status.Errorf(codes.InvalidArgument, fmt.Sprintf("Invalid %v, expected one key of at most %v characters", grpcclient.IdempotencyKeyHeader, maxIdempotencyKeyLength))
+	}
+	return values[0], nil
+}
+
+// findCreated returns the blog created by author with the idempotency key,
+// nil when there is none
+func findCreated(ctx context.Context, author, key string) (*blogItem, error) {
+	data := &blogItem{}
+	err := collection.FindOne(ctx, bson.M{"author_id": author, "idempotency_key": key}).Decode(data)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, fmt.Sprintf("Internal Error : %v", err))
+	}
+	return data, nil
+}
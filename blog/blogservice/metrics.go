@@ -0,0 +1,242 @@
+package blogservice
+
+import (
+	"context"
+	"greet/blog/blobstore"
+	"greet/tracing"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Metrics of the storage layer: the MongoDB commands and connections, and
+// the operations of the attachment blob store
+var (
+	mongoCommands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "blog_mongo_commands_total",
+		Help: "Total number of MongoDB commands sent by the blog service.",
+	}, []string{"command", "collection", "outcome"})
+	mongoCommandSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "blog_mongo_command_duration_seconds",
+		Help: "Histogram of the duration (seconds) of the MongoDB commands.",
+	}, []string{"command", "collection"})
+	mongoConnectionsInUse = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "blog_mongo_connections_in_use",
+		Help: "Number of MongoDB connections checked out of the pool.",
+	})
+	blobOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "blog_blobstore_operations_total",
+		Help: "Total number of attachment blob store operations.",
+	}, []string{"operation", "outcome"})
+	blobOperationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "blog_blobstore_operation_duration_seconds",
+		Help: "Histogram of the duration (seconds) of the attachment blob store operations.",
+	}, []string{"operation"})
+	blobBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "blog_blobstore_bytes_total",
+		Help: "Total number of attachment bytes written to and read from the blob store.",
+	}, []string{"direction"})
+)
+
+// commandMonitor records the metrics of MongoDB commands, and a span for
+// the commands made while handling a traced call. The collection a command
+// targets is only known when it starts, so it is kept with its span until
+// the command finishes.
+type commandMonitor struct {
+	commands sync.Map
+}
+
+type startedCommand struct {
+	collection string
+	span       trace.Span
+}
+
+func commandKey(connectionID string, requestID int64) string {
+	return connectionID + "/" + strconv.FormatInt(requestID, 10)
+}
+
+// commandCollection returns the collection named by a command such as
+// {find: "blog"} or {getMore: 1234, collection: "blog"}
+func commandCollection(command bson.Raw) string {
+	elems, err := command.Elements()
+	if err != nil || len(elems) == 0 {
+		return ""
+	}
+	if name, ok := elems[0].Value().StringValueOK(); ok {
+		return name
+	}
+	if name, ok := command.Lookup("collection").StringValueOK(); ok {
+		return name
+	}
+	return ""
+}
+
+func (m *commandMonitor) started(ctx context.Context, e *event.CommandStartedEvent) {
+	command := &startedCommand{collection: commandCollection(e.Command)}
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		_, command.span = tracing.Tracer().Start(ctx, "mongodb."+e.CommandName,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemMongoDB,
+				semconv.DBNameKey.String(e.DatabaseName),
+				semconv.DBOperationKey.String(e.CommandName),
+				semconv.DBMongoDBCollectionKey.String(command.collection),
+			),
+		)
+	}
+	m.commands.Store(commandKey(e.ConnectionID, e.RequestID), command)
+}
+
+func (m *commandMonitor) finished(e event.CommandFinishedEvent, failure string) {
+	key := commandKey(e.ConnectionID, e.RequestID)
+	value, _ := m.commands.Load(key)
+	m.commands.Delete(key)
+	command, ok := value.(*startedCommand)
+	if !ok {
+		command = &startedCommand{}
+	}
+	outcome := "success"
+	if failure != "" {
+		outcome = "failure"
+	}
+	if command.span != nil {
+		if failure != "" {
+			command.span.SetStatus(codes.Error, failure)
+		}
+		command.span.End()
+	}
+	mongoCommands.WithLabelValues(e.CommandName, command.collection, outcome).Inc()
+	mongoCommandSeconds.WithLabelValues(e.CommandName, command.collection).Observe(time.Duration(e.DurationNanos).Seconds())
+}
+
+func (m *commandMonitor) monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: m.started,
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			m.finished(e.CommandFinishedEvent, "")
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			m.finished(e.CommandFinishedEvent, e.Failure)
+		},
+	}
+}
+
+// poolMonitor tracks the connections checked out of the pool
+func poolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch e.Type {
+			case event.GetSucceeded:
+				mongoConnectionsInUse.Inc()
+			case event.ConnectionReturned:
+				mongoConnectionsInUse.Dec()
+			}
+		},
+	}
+}
+
+// instrumentedStore records the metrics of a blob store, and a span for
+// each of its operations
+type instrumentedStore struct {
+	blobstore.Store
+}
+
+// blobOperation times an operation on the blob key
+type blobOperation struct {
+	name  string
+	start time.Time
+	span  trace.Span
+}
+
+// startBlob starts timing an operation, along with a span when it is part
+// of a traced call
+func startBlob(ctx context.Context, name, key string) (context.Context, *blobOperation) {
+	op := &blobOperation{name: name, start: time.Now()}
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		ctx, op.span = tracing.Tracer().Start(ctx, "blobstore."+name,
+			trace.WithAttributes(attribute.String("blobstore.key", key)),
+		)
+	}
+	return ctx, op
+}
+
+func (o *blobOperation) end(err error) {
+	outcome := "success"
+	if err != nil && err != blobstore.ErrNotFound {
+		outcome = "failure"
+	}
+	if o.span != nil {
+		if outcome == "failure" {
+			o.span.RecordError(err)
+			o.span.SetStatus(codes.Error, err.Error())
+		}
+		o.span.End()
+	}
+	blobOperations.WithLabelValues(o.name, outcome).Inc()
+	blobOperationSeconds.WithLabelValues(o.name).Observe(time.Since(o.start).Seconds())
+}
+
+func (s instrumentedStore) Put(ctx context.Context, key string, r io.Reader) error {
+	ctx, op := startBlob(ctx, "put", key)
+	counter := &countingReader{r: r}
+	err := s.Store.Put(ctx, key, counter)
+	blobBytes.WithLabelValues("write").Add(float64(counter.n))
+	op.end(err)
+	return err
+}
+
+func (s instrumentedStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	ctx, op := startBlob(ctx, "open", key)
+	rc, err := s.Store.Open(ctx, key)
+	op.end(err)
+	if err != nil {
+		return nil, err
+	}
+	return &countingReadCloser{ReadCloser: rc}, nil
+}
+
+func (s instrumentedStore) Exists(ctx context.Context, key string) (bool, error) {
+	ctx, op := startBlob(ctx, "exists", key)
+	exists, err := s.Store.Exists(ctx, key)
+	op.end(err)
+	return exists, err
+}
+
+func (s instrumentedStore) Delete(ctx context.Context, key string) error {
+	ctx, op := startBlob(ctx, "delete", key)
+	err := s.Store.Delete(ctx, key)
+	op.end(err)
+	return err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingReadCloser records the bytes read from a blob
+type countingReadCloser struct {
+	io.ReadCloser
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	blobBytes.WithLabelValues("read").Add(float64(n))
+	return n, err
+}
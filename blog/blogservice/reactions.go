@@ -0,0 +1,168 @@
+package blogservice
+
+import (
+	"context"
+	"fmt"
+	"greet/auth"
+	"greet/blog/blogpb"
+	"greet/logging"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Likes and views are counted with single atomic increments on the blog
+// document so that concurrent updates are never lost. Who liked a post is
+// kept apart, one document per like in the likes collection, unique per
+// blog and user, which makes likes idempotent per user without growing the
+// blog documents every read loads.
+
+var likes *mongo.Collection
+
+// likeItem records that a user liked a blog
+type likeItem struct {
+	BlogID primitive.ObjectID `bson:"blog_id"`
+	UserID string             `bson:"user_id"`
+}
+
+// createLikesIndex makes the likes unique per blog and user, so that
+// concurrent likes of a user cannot both count
+func createLikesIndex(ctx context.Context) error {
+	_, err := likes.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "blog_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetName("blog_id_user_id").SetUnique(true),
+	})
+	return err
+}
+
+// updateCounters applies update to the blog matching filter and returns the
+// updated blog, or nil when no blog matches
+func updateCounters(ctx context.Context, filter bson.M, update bson.M) (*blogItem, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	data := &blogItem{}
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(data)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// findBlog returns the blog with the given ID as a gRPC response would carry it
+func findBlog(ctx context.Context, oid primitive.ObjectID) (*blogpb.Blog, error) {
+	data := &blogItem{}
+	if err := collection.FindOne(ctx, bson.M{"_id": oid}).Decode(data); err != nil {
+		return nil, status.Errorf(
+			codes.NotFound,
+			fmt.Sprintf("Cannot find blog with specified ID : %v", err),
+		)
+	}
+	return dataToBlobPb(data), nil
+}
+
+// react likes (inc = 1) or unlikes (inc = -1) a blog on behalf of the caller.
+// Liking twice or unliking a blog which is not liked leaves it unchanged.
+func react(ctx context.Context, blogID string, inc int) (*blogpb.Blog, error) {
+	caller, err := auth.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := primitive.ObjectIDFromHex(blogID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID"))
+	}
+	like := likeItem{BlogID: oid, UserID: caller.Subject}
+	if inc > 0 {
+		// Only like blogs which exist
+		if _, err := findBlog(ctx, oid); err != nil {
+			return nil, err
+		}
+		_, err := likes.InsertOne(ctx, like)
+		if mongo.IsDuplicateKeyError(err) {
+			return findBlog(ctx, oid)
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, fmt.Sprintf("Cannot insert like in MongoDB : %v", err))
+		}
+	} else {
+		res, err := likes.DeleteOne(ctx, like)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, fmt.Sprintf("Cannot delete like in MongoDB : %v", err))
+		}
+		if res.DeletedCount == 0 {
+			return findBlog(ctx, oid)
+		}
+	}
+	data, err := updateCounters(ctx, bson.M{"_id": oid}, bson.M{"$inc": bson.M{"like_count": inc}})
+	if err != nil {
+		undoReaction(ctx, like, inc)
+		return nil, status.Errorf(codes.Internal, fmt.Sprintf("Cannot update object in MongoDB : %v", err))
+	}
+	if data == nil {
+		// The blog was deleted meanwhile
+		undoReaction(ctx, like, inc)
+		return nil, status.Errorf(codes.NotFound, fmt.Sprintf("Cannot find blog with specified ID : %v", blogID))
+	}
+	return dataToBlobPb(data), nil
+}
+
+// undoReaction reverts the like or unlike recorded by react when the count
+// cannot follow, so that a retry counts it. It runs even when the call was
+// cancelled, and only logs its failures.
+func undoReaction(ctx context.Context, like likeItem, inc int) {
+	var err error
+	if inc > 0 {
+		_, err = likes.DeleteOne(context.Background(), like)
+	} else {
+		_, err = likes.InsertOne(context.Background(), like)
+	}
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		logging.FromContext(ctx).Warn("Cannot undo reaction", logging.F("blog_id", like.BlogID.Hex()), logging.F("user_id", like.UserID), logging.Err(err))
+	}
+}
+
+// deleteLikes deletes the likes of a deleted blog
+func deleteLikes(ctx context.Context, oid primitive.ObjectID) error {
+	_, err := likes.DeleteMany(ctx, bson.M{"blog_id": oid})
+	return err
+}
+
+/** Like Blog **/
+func (*Server) LikeBlog(ctx context.Context, req *blogpb.LikeBlogRequest) (*blogpb.LikeBlogResponse, error) {
+	blog, err := react(ctx, req.GetBlogId(), 1)
+	if err != nil {
+		return nil, err
+	}
+	return &blogpb.LikeBlogResponse{Blog: blog}, nil
+}
+
+/** Unlike Blog **/
+func (*Server) UnlikeBlog(ctx context.Context, req *blogpb.UnlikeBlogRequest) (*blogpb.UnlikeBlogResponse, error) {
+	blog, err := react(ctx, req.GetBlogId(), -1)
+	if err != nil {
+		return nil, err
+	}
+	return &blogpb.UnlikeBlogResponse{Blog: blog}, nil
+}
+
+/** Record View **/
+func (*Server) RecordView(ctx context.Context, req *blogpb.RecordViewRequest) (*blogpb.RecordViewResponse, error) {
+	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID"))
+	}
+	data, err := updateCounters(ctx, bson.M{"_id": oid}, bson.M{"$inc": bson.M{"view_count": 1}})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, fmt.Sprintf("Cannot update object in MongoDB : %v", err))
+	}
+	if data == nil {
+		return nil, status.Errorf(codes.NotFound, fmt.Sprintf("Cannot find blog with specified ID : %v", req.GetBlogId()))
+	}
+	return &blogpb.RecordViewResponse{Blog: dataToBlobPb(data)}, nil
+}
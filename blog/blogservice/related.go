@@ -0,0 +1,248 @@
+package blogservice
+
+import (
+	"context"
+	"fmt"
+	"greet/blog/blogpb"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultRelatedLimit = 5
+	maxRelatedLimit     = 50
+	// Terms of the title count as much as this many occurrences in the content
+	titleWeight = 2
+)
+
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "but": true, "not": true,
+	"you": true, "all": true, "any": true, "can": true, "had": true, "her": true,
+	"was": true, "one": true, "our": true, "out": true, "has": true, "have": true,
+	"his": true, "how": true, "its": true, "may": true, "who": true, "this": true,
+	"that": true, "with": true, "from": true, "they": true, "will": true, "what": true,
+	"when": true, "your": true, "into": true, "than": true, "then": true, "them": true,
+	"these": true, "those": true, "there": true, "their": true, "were": true, "been": true,
+	"which": true, "would": true, "about": true, "also": true, "some": true, "more": true,
+}
+
+// terms splits text into lower cased words, dropping short and common ones
+func terms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	res := words[:0]
+	for _, w := range words {
+		if len([]rune(w)) >= 3 && !stopWords[w] {
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+type scoredBlog struct {
+	id    string
+	score float64
+}
+
+// relatedIndex keeps the term frequencies of every blog and an inverted index
+// from terms to blogs, updated as blogs are created, updated and deleted.
+// TF-IDF weights depend on the whole corpus so they are computed at query time.
+// The index lives in memory and is rebuilt from MongoDB on startup.
+type relatedIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]map[string]int
+	postings map[string]map[string]bool
+}
+
+func newRelatedIndex() *relatedIndex {
+	return &relatedIndex{
+		docs:     map[string]map[string]int{},
+		postings: map[string]map[string]bool{},
+	}
+}
+
+// load indexes every blog stored in MongoDB
+func (ix *relatedIndex) load(ctx context.Context) error {
+	cur, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		data := &blogItem{}
+		if err := cur.Decode(data); err != nil {
+			return err
+		}
+		ix.add(data.ID.Hex(), data.Title, data.Content)
+	}
+	return cur.Err()
+}
+
+// add indexes a blog, replacing its previous version if any
+func (ix *relatedIndex) add(id string, title string, content string) {
+	tf := map[string]int{}
+	for _, t := range terms(title) {
+		tf[t] += titleWeight
+	}
+	for _, t := range terms(content) {
+		tf[t]++
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.removeLocked(id)
+	ix.docs[id] = tf
+	for t := range tf {
+		if ix.postings[t] == nil {
+			ix.postings[t] = map[string]bool{}
+		}
+		ix.postings[t][id] = true
+	}
+}
+
+func (ix *relatedIndex) remove(id string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.removeLocked(id)
+}
+
+func (ix *relatedIndex) removeLocked(id string) {
+	for t := range ix.docs[id] {
+		delete(ix.postings[t], id)
+		if len(ix.postings[t]) == 0 {
+			delete(ix.postings, t)
+		}
+	}
+	delete(ix.docs, id)
+}
+
+// weights returns the TF-IDF vector of a blog and its norm
+func (ix *relatedIndex) weights(tf map[string]int) (map[string]float64, float64) {
+	n := float64(len(ix.docs))
+	w := make(map[string]float64, len(tf))
+	norm := 0.0
+	for t, f := range tf {
+		idf := math.Log(1 + n/float64(len(ix.postings[t])))
+		w[t] = (1 + math.Log(float64(f))) * idf
+		norm += w[t] * w[t]
+	}
+	return w, math.Sqrt(norm)
+}
+
+// related returns up to limit blogs sharing terms with id, by decreasing
+// cosine similarity. ok is false when the blog is not indexed.
+func (ix *relatedIndex) related(id string, limit int) (res []scoredBlog, ok bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	tf, ok := ix.docs[id]
+	if !ok {
+		return nil, false
+	}
+	query, queryNorm := ix.weights(tf)
+	if queryNorm == 0 {
+		return nil, true
+	}
+	candidates := map[string]bool{}
+	for t := range tf {
+		for other := range ix.postings[t] {
+			if other != id {
+				candidates[other] = true
+			}
+		}
+	}
+	for other := range candidates {
+		w, norm := ix.weights(ix.docs[other])
+		if norm == 0 {
+			continue
+		}
+		dot := 0.0
+		for t, qw := range query {
+			dot += qw * w[t]
+		}
+		res = append(res, scoredBlog{id: other, score: dot / (queryNorm * norm)})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].score != res[j].score {
+			return res[i].score > res[j].score
+		}
+		return res[i].id < res[j].id
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, true
+}
+
+/** List Related Blogs **/
+func (s *Server) ListRelatedBlogs(ctx context.Context, req *blogpb.ListRelatedBlogsRequest) (*blogpb.ListRelatedBlogsResponse, error) {
+	if _, err := primitive.ObjectIDFromHex(req.GetBlogId()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID"))
+	}
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = defaultRelatedLimit
+	}
+	if limit > maxRelatedLimit {
+		limit = maxRelatedLimit
+	}
+	scored, ok := s.related.related(req.GetBlogId(), limit)
+	if !ok {
+		return nil, status.Errorf(
+			codes.NotFound,
+			fmt.Sprintf("Cannot find blog with specified ID : %v", req.GetBlogId()),
+		)
+	}
+	res := &blogpb.ListRelatedBlogsResponse{}
+	if len(scored) == 0 {
+		return res, nil
+	}
+
+	ids := bson.A{}
+	for _, sb := range scored {
+		oid, _ := primitive.ObjectIDFromHex(sb.id)
+		ids = append(ids, oid)
+	}
+	cur, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Unknown Internal Error : %v", err),
+		)
+	}
+	defer cur.Close(ctx)
+	blogs := map[string]*blogItem{}
+	for cur.Next(ctx) {
+		data := &blogItem{}
+		if err := cur.Decode(data); err != nil {
+			return nil, status.Errorf(
+				codes.Internal,
+				fmt.Sprintf("Error while decoding data from MongoDB: %v", err),
+			)
+		}
+		blogs[data.ID.Hex()] = data
+	}
+	if err := cur.Err(); err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Unknown internal error : %v", err),
+		)
+	}
+	// Keep the order of the scores, skipping blogs deleted in the meantime
+	for _, sb := range scored {
+		if data, ok := blogs[sb.id]; ok {
+			res.Blogs = append(res.Blogs, &blogpb.RelatedBlog{
+				Blog:  dataToBlobPb(data),
+				Score: sb.score,
+			})
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,359 @@
+// Package blogservice implements the blog.BlogService gRPC service, backed
+// by MongoDB and a blob store for attachments, and the HTTP feeds of the
+// blogs.
+package blogservice
+
+import (
+	"context"
+	"fmt"
+	"greet/auth"
+	"greet/blog/blobstore"
+	"greet/blog/blogpb"
+	"greet/logging"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceName is the name of the blog service in the health service
+const ServiceName = "blog.BlogService"
+
+var collection *mongo.Collection
+
+// Server implements blogpb.BlogServiceServer. It owns the MongoDB client
+// and the feed HTTP server, released by Close.
+type Server struct {
+	feeds             *feedGenerator
+	blobs             blobstore.Store
+	blobLocks         keyedMutex
+	maxAttachmentSize int64
+	related           *relatedIndex
+	client            *mongo.Client
+	feedServer        *http.Server
+}
+
+// New connects to MongoDB, indexes the blogs for ListRelatedBlogs and opens
+// the attachment store
+func New(ctx context.Context, cfg Config) (*Server, error) {
+	monitor := &commandMonitor{}
+	client, err := mongo.NewClient(options.Client().
+		ApplyURI(cfg.Mongo.URI).
+		SetMonitor(monitor.monitor()).
+		SetPoolMonitor(poolMonitor()))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Connect(ctx); err != nil {
+		return nil, err
+	}
+	logging.Info("MongoDB connected", logging.F("database", cfg.Mongo.Database))
+	collection = client.Database(cfg.Mongo.Database).Collection("blog")
+	attachments = client.Database(cfg.Mongo.Database).Collection("attachments")
+	likes = client.Database(cfg.Mongo.Database).Collection("likes")
+	if err := createIdempotencyIndex(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, fmt.Errorf("indexing idempotency keys: %v", err)
+	}
+	if err := createLikesIndex(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, fmt.Errorf("indexing likes: %v", err)
+	}
+
+	s := &Server{
+		feeds:             &feedGenerator{baseURL: cfg.Feed.BaseURL},
+		maxAttachmentSize: cfg.Attachments.MaxSize,
+		related:           newRelatedIndex(),
+		client:            client,
+	}
+	if err := s.related.load(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, fmt.Errorf("indexing blogs: %v", err)
+	}
+	blobs, err := blobstore.NewLocal(cfg.Attachments.Dir)
+	if err != nil {
+		client.Disconnect(ctx)
+		return nil, fmt.Errorf("opening attachment store: %v", err)
+	}
+	s.blobs = instrumentedStore{blobs}
+	if cfg.Feed.Addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/feed/", s.feeds)
+		s.feedServer = &http.Server{
+			Addr:              cfg.Feed.Addr,
+			Handler:           mux,
+			ReadHeaderTimeout: feedReadHeaderTimeout,
+			ReadTimeout:       feedReadTimeout,
+			WriteTimeout:      feedWriteTimeout,
+			IdleTimeout:       feedIdleTimeout,
+		}
+	}
+	return s, nil
+}
+
+// Register registers the blog service on g
+func (s *Server) Register(g *grpc.Server) {
+	blogpb.RegisterBlogServiceServer(g, s)
+}
+
+// Ping checks MongoDB answers, reported by the health service
+func (s *Server) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx, readpref.Primary())
+}
+
+// ServeFeeds serves the RSS and Atom feeds over plain HTTP for feed
+// readers until Close, it returns immediately when feeds are disabled
+func (s *Server) ServeFeeds() error {
+	if s.feedServer == nil {
+		return nil
+	}
+	logging.Info("Serving feeds", logging.F("addr", s.feedServer.Addr))
+	if err := s.feedServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Close stops the feed server, letting the requests in flight end until
+// ctx is done, then closes the connection with MongoDB. The gRPC server is
+// expected to be stopped first.
+func (s *Server) Close(ctx context.Context) error {
+	if s.feedServer != nil {
+		if err := s.feedServer.Shutdown(ctx); err != nil {
+			s.feedServer.Close()
+		}
+	}
+	logging.Info("Closing the MongoDB connection")
+	return s.client.Disconnect(ctx)
+}
+
+type blogItem struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	AuthorID  string             `bson:"author_id"`
+	Content   string             `bson:"content"`
+	Title     string             `bson:"title"`
+	UpdatedAt time.Time          `bson:"updated_at,omitempty"`
+	LikeCount int64              `bson:"like_count"`
+	ViewCount int64              `bson:"view_count"`
+	// IdempotencyKey is the key the blog was created with, if any
+	IdempotencyKey string `bson:"idempotency_key,omitempty"`
+}
+
+/** Create Blog **/
+func (s *Server) CreateBlog(ctx context.Context, req *blogpb.CreateBlogRequest) (*blogpb.CreateBlogResponse, error) {
+
+	// The author is always the caller, whatever the request says
+	caller, err := auth.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, err := idempotencyKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// A retried create returns the blog created by the first attempt
+	if key != "" {
+		created, err := findCreated(ctx, caller.Subject, key)
+		if err != nil {
+			return nil, err
+		}
+		if created != nil {
+			return &blogpb.CreateBlogResponse{Blog: dataToBlobPb(created)}, nil
+		}
+	}
+
+	blog := req.GetBlog()
+	data := blogItem{
+		AuthorID:       caller.Subject,
+		Title:          blog.GetTitle(),
+		Content:        blog.GetContent(),
+		UpdatedAt:      time.Now(),
+		IdempotencyKey: key,
+	}
+	res, err := collection.InsertOne(ctx, data)
+	if key != "" && mongo.IsDuplicateKeyError(err) {
+		// Another attempt created it in the meantime
+		created, err := findCreated(ctx, caller.Subject, key)
+		if err != nil {
+			return nil, err
+		}
+		if created != nil {
+			return &blogpb.CreateBlogResponse{Blog: dataToBlobPb(created)}, nil
+		}
+	}
+	if err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Internal Error : %v", err),
+		)
+	}
+	oid, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Cannot convert to OID : %v", oid),
+		)
+	}
+	s.related.add(oid.Hex(), blog.GetTitle(), blog.GetContent())
+
+	return &blogpb.CreateBlogResponse{
+		Blog: &blogpb.Blog{
+			Id:       oid.Hex(),
+			AuthorId: caller.Subject,
+			Title:    blog.GetTitle(),
+			Content:  blog.GetContent(),
+		},
+	}, nil
+
+}
+
+/** Read Blog **/
+func (*Server) ReadBlog(ctx context.Context, req *blogpb.ReadBlogRequest) (*blogpb.ReadBlogResponse, error) {
+	blogID := req.GetBlogId()
+	oid, err := primitive.ObjectIDFromHex(blogID)
+	if err != nil {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			fmt.Sprintf("Cannot Parse ID"),
+		)
+	}
+	// Create an empty struct to store read result
+	data := &blogItem{}
+	filter := bson.M{"_id": oid}
+	res := collection.FindOne(ctx, filter)
+	if err := res.Decode(data); err != nil {
+		return nil, status.Error(
+			codes.NotFound,
+			fmt.Sprintf("Cannot find the blog with ID : %v", err),
+		)
+	}
+	return &blogpb.ReadBlogResponse{
+		Blog: dataToBlobPb(data),
+	}, nil
+}
+
+func dataToBlobPb(data *blogItem) *blogpb.Blog {
+	return &blogpb.Blog{
+		Id:        data.ID.Hex(),
+		AuthorId:  data.AuthorID,
+		Content:   data.Content,
+		Title:     data.Title,
+		LikeCount: data.LikeCount,
+		ViewCount: data.ViewCount,
+	}
+}
+
+/** Update Blog **/
+func (s *Server) UpdateBlog(ctx context.Context, req *blogpb.UpdateBlogRequest) (*blogpb.UpdateBlogResponse, error) {
+	caller, err := auth.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	blog := req.GetBlog()
+	oid, err := primitive.ObjectIDFromHex(blog.GetId())
+	if err != nil {
+		return nil, status.Error(
+			codes.InvalidArgument,
+			fmt.Sprintf("Cannot parse ID"),
+		)
+	}
+	// Only set the edited fields, so that likes and views recorded
+	// concurrently are kept. The author of a blog never changes.
+	data := &blogItem{}
+	filter := ownedBy(caller, oid)
+	update := bson.M{"$set": bson.M{
+		"content":    blog.GetContent(),
+		"title":      blog.GetTitle(),
+		"updated_at": time.Now(),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	updateErr := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(data)
+	if updateErr == mongo.ErrNoDocuments {
+		return nil, notOwnedError(ctx, oid)
+	}
+	if updateErr != nil {
+		return nil, status.Error(
+			codes.Internal,
+			fmt.Sprintf("Cannot update object in MongoDB : %v", updateErr),
+		)
+	}
+	s.related.add(data.ID.Hex(), data.Title, data.Content)
+	return &blogpb.UpdateBlogResponse{
+		Blog: dataToBlobPb(data),
+	}, nil
+}
+
+/** Delete Blog **/
+func (s *Server) DeleteBlog(ctx context.Context, req *blogpb.DeleteBlogRequest) (*blogpb.DeleteBlogResponse, error) {
+	caller, err := auth.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			fmt.Sprintf("Cannot parse ID"),
+		)
+	}
+	res, err := collection.DeleteOne(ctx, ownedBy(caller, oid))
+	if err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Cannot Delete object in MongoDB: %v", err),
+		)
+	}
+	if res.DeletedCount == 0 {
+		return nil, notOwnedError(ctx, oid)
+	}
+	s.related.remove(oid.Hex())
+	// The blog is gone whatever happens to its attachments and likes
+	if err := s.deleteAttachments(ctx, oid); err != nil {
+		logging.FromContext(ctx).Error("Blog deleted but cannot delete its attachments", logging.F("blog_id", oid.Hex()), logging.Err(err))
+	}
+	if err := deleteLikes(ctx, oid); err != nil {
+		logging.FromContext(ctx).Error("Blog deleted but cannot delete its likes", logging.F("blog_id", oid.Hex()), logging.Err(err))
+	}
+	return &blogpb.DeleteBlogResponse{BlogId: req.GetBlogId()}, nil
+}
+
+/** List Blogs **/
+func (*Server) ListBlog(req *blogpb.ListBlogRequest, stream blogpb.BlogService_ListBlogServer) error {
+	ctx := stream.Context()
+	cur, err := collection.Find(ctx, bson.D{{}})
+	if err != nil {
+		return status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Unknown Internal Error : %v", err),
+		)
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		data := &blogItem{}
+		err := cur.Decode(data)
+		if err != nil {
+			return status.Errorf(
+				codes.Internal,
+				fmt.Sprintf("Error while decoding data from MongoDB: %v", err),
+			)
+		}
+		if err := stream.Send(&blogpb.ListBlogResponse{Blog: dataToBlobPb(data)}); err != nil {
+			return err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Unknown internal error : %v", err),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package blogservice
+
+import (
+	"context"
+	"fmt"
+	"greet/blog/blogpb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Lower bounds of the content length buckets, the last one is unbounded
+var contentLengthBoundaries = []int64{0, 100, 500, 1000, 5000, 10000}
+
+type totalsStats struct {
+	Count     int64   `bson:"count"`
+	AvgLength float64 `bson:"avg_length"`
+	MinLength int64   `bson:"min_length"`
+	MaxLength int64   `bson:"max_length"`
+	Likes     int64   `bson:"likes"`
+	Views     int64   `bson:"views"`
+}
+
+type authorStats struct {
+	AuthorID  string  `bson:"_id"`
+	Count     int64   `bson:"count"`
+	AvgLength float64 `bson:"avg_length"`
+}
+
+type bucketStats struct {
+	MinLength int64 `bson:"_id"`
+	Count     int64 `bson:"count"`
+}
+
+type blogStats struct {
+	Totals  []totalsStats `bson:"totals"`
+	Authors []authorStats `bson:"authors"`
+	Buckets []bucketStats `bson:"buckets"`
+}
+
+// statsPipeline computes every statistic in a single aggregation, each
+// facet working on the content length projected by the first stage
+func statsPipeline() mongo.Pipeline {
+	last := contentLengthBoundaries[len(contentLengthBoundaries)-1]
+	boundaries := bson.A{}
+	for _, b := range contentLengthBoundaries {
+		boundaries = append(boundaries, b)
+	}
+	// $bucket needs an upper bound, documents above it fall in the default bucket
+	boundaries = append(boundaries, last+1)
+	return mongo.Pipeline{
+		{{Key: "$project", Value: bson.M{
+			"author_id":  1,
+			"like_count": bson.M{"$ifNull": bson.A{"$like_count", 0}},
+			"view_count": bson.M{"$ifNull": bson.A{"$view_count", 0}},
+			"length":     bson.M{"$strLenCP": bson.M{"$ifNull": bson.A{"$content", ""}}},
+		}}},
+		{{Key: "$facet", Value: bson.M{
+			"totals": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":        nil,
+					"count":      bson.M{"$sum": 1},
+					"avg_length": bson.M{"$avg": "$length"},
+					"min_length": bson.M{"$min": "$length"},
+					"max_length": bson.M{"$max": "$length"},
+					"likes":      bson.M{"$sum": "$like_count"},
+					"views":      bson.M{"$sum": "$view_count"},
+				}},
+			},
+			"authors": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":        "$author_id",
+					"count":      bson.M{"$sum": 1},
+					"avg_length": bson.M{"$avg": "$length"},
+				}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+			"buckets": bson.A{
+				bson.M{"$bucket": bson.M{
+					"groupBy":    bson.M{"$min": bson.A{"$length", last}},
+					"boundaries": boundaries,
+					"output":     bson.M{"count": bson.M{"$sum": 1}},
+				}},
+			},
+		}}},
+	}
+}
+
+func statsToPb(stats *blogStats) *blogpb.GetBlogStatsResponse {
+	res := &blogpb.GetBlogStatsResponse{
+		TotalAuthors: int64(len(stats.Authors)),
+	}
+	if len(stats.Totals) > 0 {
+		totals := stats.Totals[0]
+		res.TotalBlogs = totals.Count
+		res.AverageContentLength = totals.AvgLength
+		res.MinContentLength = totals.MinLength
+		res.MaxContentLength = totals.MaxLength
+		res.TotalLikes = totals.Likes
+		res.TotalViews = totals.Views
+	}
+	for _, a := range stats.Authors {
+		res.Authors = append(res.Authors, &blogpb.AuthorStats{
+			AuthorId:             a.AuthorID,
+			BlogCount:            a.Count,
+			AverageContentLength: a.AvgLength,
+		})
+	}
+	// Empty buckets are missing from the aggregation result
+	counts := map[int64]int64{}
+	for _, b := range stats.Buckets {
+		counts[b.MinLength] = b.Count
+	}
+	for i, lower := range contentLengthBoundaries {
+		bucket := &blogpb.ContentLengthBucket{MinLength: lower, Count: counts[lower]}
+		if i+1 < len(contentLengthBoundaries) {
+			bucket.MaxLength = contentLengthBoundaries[i+1]
+		}
+		res.ContentLengthBuckets = append(res.ContentLengthBuckets, bucket)
+	}
+	return res
+}
+
+/** Get Blog Stats **/
+func (*Server) GetBlogStats(ctx context.Context, req *blogpb.GetBlogStatsRequest) (*blogpb.GetBlogStatsResponse, error) {
+	cur, err := collection.Aggregate(ctx, statsPipeline())
+	if err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Cannot aggregate blogs in MongoDB : %v", err),
+		)
+	}
+	defer cur.Close(ctx)
+	stats := &blogStats{}
+	if cur.Next(ctx) {
+		if err := cur.Decode(stats); err != nil {
+			return nil, status.Errorf(
+				codes.Internal,
+				fmt.Sprintf("Error while decoding data from MongoDB: %v", err),
+			)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Unknown internal error : %v", err),
+		)
+	}
+	return statsToPb(stats), nil
+}
@@ -0,0 +1,273 @@
+// Package chaos injects faults in the calls of a server, for testing how
+// its callers cope with latency, errors and broken streams.
+//
+// The faults are set at runtime with the ChaosService admin RPC, none is
+// injected until then. A server only installs the interceptors and the
+// admin service when chaos is enabled in its configuration.
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"greet/auth"
+	"greet/chaos/chaospb"
+	"greet/logging"
+	"math/rand"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// ServiceName is the name of the admin service, whose calls are never
+// affected
+const ServiceName = "chaos.ChaosService"
+
+var injected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "chaos_faults_injected_total",
+	Help: "Total number of RPCs affected by an injected fault.",
+}, []string{"grpc_method", "fault"})
+
+// Injector holds the faults injected in the calls, and serves the admin
+// service changing them
+type Injector struct {
+	mu     sync.RWMutex
+	faults []*chaospb.Fault
+
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+// NewInjector returns an injector with no fault
+func NewInjector() *Injector {
+	return &Injector{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Register registers the admin service on s
+func (in *Injector) Register(s *grpc.Server) {
+	chaospb.RegisterChaosServiceServer(s, in)
+}
+
+// validate checks a fault can be injected
+func validate(f *chaospb.Fault) error {
+	if !strings.HasPrefix(f.GetMethod(), "/") && f.GetMethod() != "*" {
+		return fmt.Errorf("%q is not a full method name such as /greet.GreetService/Greet", f.GetMethod())
+	}
+	if _, err := path.Match(f.GetMethod(), ""); err != nil {
+		return fmt.Errorf("%q: %v", f.GetMethod(), err)
+	}
+	if f.GetPercentage() < 0 || f.GetPercentage() > 100 {
+		return fmt.Errorf("%v: percentage must be between 0 and 100", f.GetMethod())
+	}
+	if f.GetDelayMs() < 0 || f.GetAbortAfterMessages() < 0 {
+		return fmt.Errorf("%v: delay_ms and abort_after_messages cannot be negative", f.GetMethod())
+	}
+	if f.GetCode() < 0 || f.GetCode() > int32(codes.Unauthenticated) {
+		return fmt.Errorf("%v: unknown status code %v", f.GetMethod(), f.GetCode())
+	}
+	return nil
+}
+
+// requireAdmin only lets callers with the admin role change the faults
+func requireAdmin(ctx context.Context) (*auth.Principal, error) {
+	caller, err := auth.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !caller.IsAdmin() {
+		return nil, status.Errorf(codes.PermissionDenied, fmt.Sprintf("%v is not allowed to change the injected faults", caller.Subject))
+	}
+	return caller, nil
+}
+
+/** Set Faults **/
+func (in *Injector) SetFaults(ctx context.Context, req *chaospb.SetFaultsRequest) (*chaospb.SetFaultsResponse, error) {
+	caller, err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	faults := make([]*chaospb.Fault, len(req.GetFaults()))
+	for i, f := range req.GetFaults() {
+		if err := validate(f); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Invalid fault : %v", err))
+		}
+		faults[i] = proto.Clone(f).(*chaospb.Fault)
+	}
+	in.mu.Lock()
+	in.faults = faults
+	in.mu.Unlock()
+
+	methods := make([]string, len(faults))
+	for i, f := range faults {
+		methods[i] = f.GetMethod()
+	}
+	logging.FromContext(ctx).Warn("Injected faults changed", logging.F("subject", caller.Subject), logging.F("faults", methods))
+	return &chaospb.SetFaultsResponse{Faults: faults}, nil
+}
+
+/** List Faults **/
+func (in *Injector) ListFaults(ctx context.Context, req *chaospb.ListFaultsRequest) (*chaospb.ListFaultsResponse, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	return &chaospb.ListFaultsResponse{Faults: in.faults}, nil
+}
+
+// pick returns the fault to inject in a call of fullMethod, or nil
+func (in *Injector) pick(fullMethod string) *chaospb.Fault {
+	if strings.HasPrefix(fullMethod, "/"+ServiceName+"/") {
+		return nil
+	}
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	for _, f := range in.faults {
+		if !auth.MatchMethod([]string{f.GetMethod()}, fullMethod) {
+			continue
+		}
+		if in.roll() < f.GetPercentage() {
+			return f
+		}
+		return nil
+	}
+	return nil
+}
+
+// roll returns a random percentage
+func (in *Injector) roll() float64 {
+	in.randMu.Lock()
+	defer in.randMu.Unlock()
+	return in.rand.Float64() * 100
+}
+
+// delay sleeps for the latency of the fault, or until ctx is done
+func delay(ctx context.Context, fullMethod string, f *chaospb.Fault) error {
+	if f.GetDelayMs() <= 0 {
+		return nil
+	}
+	injected.WithLabelValues(fullMethod, "delay").Inc()
+	timer := time.NewTimer(time.Duration(f.GetDelayMs()) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// faultError returns the error of the fault, nil for latency only faults
+func faultError(fullMethod string, f *chaospb.Fault, code codes.Code) error {
+	if code == codes.OK {
+		return nil
+	}
+	injected.WithLabelValues(fullMethod, "error").Inc()
+	msg := f.GetMessage()
+	if msg == "" {
+		msg = fmt.Sprintf("Fault injected in %v", fullMethod)
+	}
+	return status.Error(code, msg)
+}
+
+// UnaryServerInterceptor injects the faults in unary calls
+func (in *Injector) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		f := in.pick(info.FullMethod)
+		if f == nil {
+			return handler(ctx, req)
+		}
+		if err := delay(ctx, info.FullMethod, f); err != nil {
+			return nil, err
+		}
+		if err := faultError(info.FullMethod, f, codes.Code(f.GetCode())); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor injects the faults in streaming calls, breaking
+// them after some messages when the fault says so
+func (in *Injector) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		f := in.pick(info.FullMethod)
+		if f == nil {
+			return handler(srv, ss)
+		}
+		if err := delay(ss.Context(), info.FullMethod, f); err != nil {
+			return err
+		}
+		if f.GetAbortAfterMessages() <= 0 {
+			if err := faultError(info.FullMethod, f, codes.Code(f.GetCode())); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}
+
+		code := codes.Code(f.GetCode())
+		if code == codes.OK {
+			code = codes.Aborted
+		}
+		stream := &abortingStream{ServerStream: ss, left: int(f.GetAbortAfterMessages())}
+		stream.abort = func() error { return faultError(info.FullMethod, f, code) }
+		err := handler(srv, stream)
+		if broken := stream.broken(); broken != nil {
+			// The stream broke, whatever the handler made of it
+			return broken
+		}
+		return err
+	}
+}
+
+// abortingStream fails once left messages went through it
+type abortingStream struct {
+	grpc.ServerStream
+	abort func() error
+
+	mu   sync.Mutex
+	left int
+	err  error
+}
+
+func (s *abortingStream) next() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.left <= 0 {
+		s.err = s.abort()
+		return s.err
+	}
+	s.left--
+	return nil
+}
+
+// broken returns the error the stream failed with, if it did
+func (s *abortingStream) broken() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *abortingStream) SendMsg(m interface{}) error {
+	if err := s.next(); err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *abortingStream) RecvMsg(m interface{}) error {
+	if err := s.next(); err != nil {
+		return err
+	}
+	return s.ServerStream.RecvMsg(m)
+}
@@ -0,0 +1,127 @@
+// Command apikey manages the API key store read by the greet and blog servers.
+//
+//	apikey -store apikeys.json mint -name billing -scopes '/blog.BlogService/ReadBlog,/blog.BlogService/ListBlog' -valid-for 2160h
+//	apikey -store apikeys.json rotate -id 3f2a... -overlap 24h
+//	apikey -store apikeys.json revoke -id 3f2a...
+//	apikey -store apikeys.json list
+//
+// Keys are only printed when minted, the store keeps salted hashes.
+// Servers pick up changes to the store without restarting.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"greet/auth"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: apikey [-store file] mint|rotate|revoke|list [flags]\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func main() {
+
+	log.SetFlags(0)
+	storePath := flag.String("store", "apikeys.json", "API key store file")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+
+	store, err := auth.OpenAPIKeyStore(*storePath)
+	if err != nil {
+		log.Fatalf("Cannot open API key store : %v", err)
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	switch cmd {
+	case "mint":
+		name := fs.String("name", "", "Name of the caller owning the key")
+		scopes := fs.String("scopes", "", "Comma separated full methods the key may call, such as /blog.BlogService/* (required)")
+		roles := fs.String("roles", "", "Comma separated roles granted to the key")
+		notBefore := fs.Duration("not-before", 0, "Delay before the key becomes valid")
+		validFor := fs.Duration("valid-for", 90*24*time.Hour, "Validity of the key, 0 for no expiry")
+		fs.Parse(args)
+		if *scopes == "" {
+			log.Fatalf("-scopes is required, use '*' to allow every method")
+		}
+		start := time.Now().Add(*notBefore)
+		var end time.Time
+		if *validFor > 0 {
+			end = start.Add(*validFor)
+		}
+		key, r, err := store.Mint(*name, splitList(*scopes), splitList(*roles), start, end)
+		if err != nil {
+			log.Fatalf("Cannot mint API key : %v", err)
+		}
+		save(store)
+		fmt.Printf("Minted key %v for %v, it will not be shown again:\n%v\n", r.ID, r.Name, key)
+	case "rotate":
+		id := fs.String("id", "", "ID of the key to replace")
+		overlap := fs.Duration("overlap", 24*time.Hour, "How long the old key stays valid")
+		validFor := fs.Duration("valid-for", 90*24*time.Hour, "Validity of the new key, 0 for no expiry")
+		fs.Parse(args)
+		key, r, err := store.Rotate(*id, *overlap, *validFor)
+		if err != nil {
+			log.Fatalf("Cannot rotate API key : %v", err)
+		}
+		save(store)
+		fmt.Printf("Key %v expires in %v, replaced by key %v, it will not be shown again:\n%v\n", *id, *overlap, r.ID, key)
+	case "revoke":
+		id := fs.String("id", "", "ID of the key to revoke")
+		fs.Parse(args)
+		if err := store.Revoke(*id); err != nil {
+			log.Fatalf("Cannot revoke API key : %v", err)
+		}
+		save(store)
+		fmt.Printf("Key %v revoked\n", *id)
+	case "list":
+		fs.Parse(args)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSTATUS\tNOT BEFORE\tNOT AFTER\tSCOPES\tROLES")
+		now := time.Now()
+		for _, r := range store.Records() {
+			state := "valid"
+			switch {
+			case r.Revoked:
+				state = "revoked"
+			case !r.ValidAt(now):
+				state = "inactive"
+			}
+			notAfter := "-"
+			if !r.NotAfter.IsZero() {
+				notAfter = r.NotAfter.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", r.ID, r.Name, state,
+				r.NotBefore.Format(time.RFC3339), notAfter, strings.Join(r.Scopes, ","), strings.Join(r.Roles, ","))
+		}
+		w.Flush()
+	default:
+		usage()
+	}
+}
+
+func save(store *auth.APIKeyStore) {
+	if err := store.Save(); err != nil {
+		log.Fatalf("Cannot save API key store : %v", err)
+	}
+}
@@ -0,0 +1,315 @@
+// Command certgen creates the certificates used by the greet and blog
+// services, replacing the openssl commands of greet/ssl/instructions.sh:
+//
+//	go run ./cmd/certgen -out greet/ssl -hosts localhost,127.0.0.1
+//
+// It writes a certificate authority (ca.crt, ca.key), a server certificate
+// (server.crt, server.pem) for the given host names and IP addresses, and a
+// client certificate for mutual TLS (client.crt, client.pem). Private keys
+// are unencrypted PKCS #8 files readable by their owner only.
+//
+// An existing CA of the output directory is reused to sign the new server
+// and client certificates, unless -new-ca is given.
+//
+// The generated files are ignored by git: they are created locally by each
+// developer, as anyone holding ca.key can issue certificates the servers
+// trust.
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type options struct {
+	keyType string
+	rsaBits int
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func (o options) generateKey() (crypto.Signer, error) {
+	switch o.keyType {
+	case "ecdsa":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	case "rsa":
+		if o.rsaBits < 2048 {
+			return nil, fmt.Errorf("RSA keys must have at least 2048 bits")
+		}
+		return rsa.GenerateKey(rand.Reader, o.rsaBits)
+	default:
+		return nil, fmt.Errorf("unknown key type %q, use ecdsa, ed25519 or rsa", o.keyType)
+	}
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// addSANs sorts names into DNS names, IP addresses and URIs
+func addSANs(tmpl *x509.Certificate, names []string) error {
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if strings.Contains(name, "://") {
+			u, err := url.Parse(name)
+			if err != nil {
+				return err
+			}
+			tmpl.URIs = append(tmpl.URIs, u)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, name)
+		}
+	}
+	return nil
+}
+
+func newTemplate(cn string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		// Tolerate some clock skew between machines
+		NotBefore: now.Add(-5 * time.Minute),
+		NotAfter:  now.Add(validity),
+	}, nil
+}
+
+// writeFile writes data atomically with the given permissions
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeCert(path string, der []byte) error {
+	return writeFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func writeKey(path string, key crypto.Signer) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func readPEM(path string, blockType string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%v: no %v found", path, blockType)
+	}
+	return block.Bytes, nil
+}
+
+// loadCA reads the CA of the output directory
+func loadCA(dir string) (*x509.Certificate, crypto.Signer, error) {
+	certDER, err := readPEM(filepath.Join(dir, "ca.crt"), "CERTIFICATE")
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := readPEM(filepath.Join(dir, "ca.key"), "PRIVATE KEY")
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyDER)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("unsupported CA key")
+	}
+	return cert, signer, nil
+}
+
+func createCA(dir string, cn string, validity time.Duration, o options) (*x509.Certificate, crypto.Signer, error) {
+	key, err := o.generateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl, err := newTemplate(cn, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.MaxPathLenZero = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeKey(filepath.Join(dir, "ca.key"), key); err != nil {
+		return nil, nil, err
+	}
+	if err := writeCert(filepath.Join(dir, "ca.crt"), der); err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// issue creates a certificate signed by the CA and writes it as name.crt
+// with its key as name.pem
+func issue(dir string, name string, tmpl *x509.Certificate, ca *x509.Certificate, caKey crypto.Signer, o options) error {
+	key, err := o.generateKey()
+	if err != nil {
+		return err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	tmpl.BasicConstraintsValid = true
+	if tmpl.NotAfter.After(ca.NotAfter) {
+		tmpl.NotAfter = ca.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, key.Public(), caKey)
+	if err != nil {
+		return err
+	}
+	if err := writeKey(filepath.Join(dir, name+".pem"), key); err != nil {
+		return err
+	}
+	return writeCert(filepath.Join(dir, name+".crt"), der)
+}
+
+func main() {
+
+	log.SetFlags(0)
+	out := flag.String("out", "greet/ssl", "Output directory")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,::1", "Comma separated DNS names, IP addresses and URIs of the server certificate")
+	keyType := flag.String("key-type", "ecdsa", "Key type: ecdsa (P-256), ed25519 or rsa")
+	rsaBits := flag.Int("rsa-bits", 3072, "Size of RSA keys")
+	days := flag.Int("days", 365, "Validity of the server and client certificates in days")
+	caDays := flag.Int("ca-days", 3650, "Validity of a new CA in days")
+	caCN := flag.String("ca-cn", "greet local CA", "Common name of a new CA")
+	newCA := flag.Bool("new-ca", false, "Create a new CA even if the output directory has one")
+	clientCN := flag.String("client-cn", "client", "Common name of the client certificate, its identity unless -client-uri is set")
+	clientURI := flag.String("client-uri", "", "URI SAN of the client certificate, such as spiffe://example.org/greet-client")
+	clientRoles := flag.String("client-roles", "", "Comma separated roles of the client, stored as organizational units")
+	flag.Parse()
+
+	o := options{keyType: *keyType, rsaBits: *rsaBits}
+	if *days <= 0 || *caDays <= 0 {
+		log.Fatalf("Validity must be positive")
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		log.Fatalf("Cannot create output directory : %v", err)
+	}
+
+	// 1. Certificate authority, trusted by servers and clients (ca.crt)
+	var ca *x509.Certificate
+	var caKey crypto.Signer
+	var err error
+	if !*newCA {
+		ca, caKey, err = loadCA(*out)
+		if err == nil {
+			fmt.Printf("Reusing the CA %q of %v\n", ca.Subject.CommonName, *out)
+		} else if !os.IsNotExist(err) {
+			log.Fatalf("Cannot reuse the existing CA, fix it or pass -new-ca : %v", err)
+		}
+	}
+	if ca == nil {
+		ca, caKey, err = createCA(*out, *caCN, time.Duration(*caDays)*24*time.Hour, o)
+		if err != nil {
+			log.Fatalf("Cannot create CA : %v", err)
+		}
+		fmt.Printf("Created the CA %q\n", ca.Subject.CommonName)
+	}
+	validity := time.Duration(*days) * 24 * time.Hour
+
+	// 2. Server certificate (server.crt, server.pem)
+	names := splitList(*hosts)
+	if len(names) == 0 {
+		log.Fatalf("-hosts needs at least one name")
+	}
+	serverTmpl, err := newTemplate(names[0], validity)
+	if err != nil {
+		log.Fatalf("Cannot create server certificate : %v", err)
+	}
+	if err := addSANs(serverTmpl, names); err != nil {
+		log.Fatalf("Invalid -hosts : %v", err)
+	}
+	serverTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	if err := issue(*out, "server", serverTmpl, ca, caKey, o); err != nil {
+		log.Fatalf("Cannot create server certificate : %v", err)
+	}
+	fmt.Printf("Created the server certificate for %v\n", strings.Join(names, ", "))
+
+	// 3. Client certificate for mutual TLS (client.crt, client.pem)
+	clientTmpl, err := newTemplate(*clientCN, validity)
+	if err != nil {
+		log.Fatalf("Cannot create client certificate : %v", err)
+	}
+	clientTmpl.Subject.OrganizationalUnit = splitList(*clientRoles)
+	if *clientURI != "" {
+		if err := addSANs(clientTmpl, []string{*clientURI}); err != nil || len(clientTmpl.URIs) == 0 {
+			log.Fatalf("Invalid -client-uri %q", *clientURI)
+		}
+	}
+	clientTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	if err := issue(*out, "client", clientTmpl, ca, caKey, o); err != nil {
+		log.Fatalf("Cannot create client certificate : %v", err)
+	}
+	fmt.Printf("Created the client certificate for %v\n", *clientCN)
+}
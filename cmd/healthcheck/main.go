@@ -0,0 +1,71 @@
+// Command healthcheck queries the grpc.health.v1.Health service of a greet,
+// blog or combined server, for use as a container liveness or readiness
+// probe. It exits with status 0 when the service is SERVING and 1
+// otherwise.
+//
+//	healthcheck -addr localhost:50052 -service blog.BlogService
+//	healthcheck -addr localhost:50051 -ca greet/ssl/ca.crt -cert greet/ssl/client.crt -key greet/ssl/client.pem
+//
+// Without -service the health of the whole server is checked.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"greet/tlsutil"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func main() {
+
+	addr := flag.String("addr", "localhost:50051", "Address of the server")
+	service := flag.String("service", "", "Service to check, empty for the whole server")
+	timeout := flag.Duration("timeout", 3*time.Second, "Time allowed to connect and get an answer")
+	caFile := flag.String("ca", "", "CA bundle trusted to verify the server, empty to connect in plaintext")
+	certFile := flag.String("cert", "", "Client certificate presented to servers requiring mutual TLS")
+	keyFile := flag.String("key", "", "Private key of the client certificate")
+	serverName := flag.String("server-name", "", "Name expected in the server certificate, defaults to the host of -addr")
+	flag.Parse()
+
+	// Client certificates are only presented over TLS
+	if (*certFile != "" || *keyFile != "") && *caFile == "" {
+		fail("-cert and -key need -ca : client certificates are only presented over TLS")
+	}
+
+	opts := []grpc.DialOption{grpc.WithInsecure(), grpc.WithBlock()}
+	if *caFile != "" {
+		certs, err := tlsutil.NewReloader(*certFile, *keyFile, *caFile)
+		if err != nil {
+			fail("Error while loading certificates : %v", err)
+		}
+		opts[0] = grpc.WithTransportCredentials(credentials.NewTLS(certs.ClientConfig(*serverName)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, *addr, opts...)
+	if err != nil {
+		fail("Could not connect to %v : %v", *addr, err)
+	}
+	defer conn.Close()
+
+	res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: *service})
+	if err != nil {
+		fail("Health check failed : %v", err)
+	}
+	fmt.Println(res.GetStatus())
+	if res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		os.Exit(1)
+	}
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
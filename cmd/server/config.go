@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"greet/blog/blogservice"
+	"greet/config"
+)
+
+// serverConfig holds the settings of the combined server, read from the
+// file named by -config or SERVER_CONFIG, SERVER_* environment variables
+// and flags
+type serverConfig struct {
+	Server   config.Server      `yaml:",inline"`
+	Services []string           `yaml:"services" usage:"Comma separated services to host, among greet and blog"`
+	Blog     blogservice.Config `yaml:"blog"`
+}
+
+func defaultConfig() *serverConfig {
+	cfg := &serverConfig{
+		Server:   config.DefaultServer(":50051"),
+		Services: []string{"greet", "blog"},
+		Blog:     blogservice.DefaultConfig(),
+	}
+	cfg.Server.MetricsAddr = "0.0.0.0:9090"
+	cfg.Server.Tracing.ServiceName = "server"
+	return cfg
+}
+
+func (c *serverConfig) Validate() error {
+	if len(c.Services) == 0 {
+		return fmt.Errorf("services: at least one service is required")
+	}
+	for _, name := range c.Services {
+		if name != "greet" && name != "blog" {
+			return fmt.Errorf("services: unknown service %q", name)
+		}
+	}
+	return nil
+}
+
+func (c *serverConfig) hosts(service string) bool {
+	for _, name := range c.Services {
+		if name == service {
+			return true
+		}
+	}
+	return false
+}
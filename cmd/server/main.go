@@ -0,0 +1,77 @@
+// Command server hosts the greet and blog services on a single gRPC
+// server, sharing its listener, TLS, authentication, RBAC and reflection.
+//
+//	server -services greet,blog -tls-cert greet/ssl/server.crt -tls-key greet/ssl/server.pem
+//
+// The greet/greetserver and blog/blog_server commands still run each
+// service on its own.
+package main
+
+import (
+	"context"
+	"fmt"
+	"greet/blog/blogservice"
+	"greet/config"
+	"greet/greet/greetservice"
+	"greet/grpcserver"
+	"greet/logging"
+	"os"
+	"time"
+)
+
+func main() {
+
+	cfg := defaultConfig()
+	if err := config.Load(cfg, "SERVER", os.Args[1:]); err != nil {
+		logging.Fatal("Invalid configuration", logging.Err(err))
+	}
+	if err := logging.Setup(cfg.Server.Log.Level, cfg.Server.Log.Format, cfg.Server.Log.Redact); err != nil {
+		logging.Fatal("Invalid log configuration", logging.Err(err))
+	}
+
+	fmt.Println("Effective configuration")
+	config.Fprint(os.Stdout, cfg)
+
+	s, err := grpcserver.New(cfg.Server)
+	if err != nil {
+		logging.Fatal("Failed to start", logging.Err(err))
+	}
+
+	if cfg.hosts("greet") {
+		greetservice.Register(s.GRPC)
+	}
+	var blog *blogservice.Server
+	if cfg.hosts("blog") {
+		blog, err = blogservice.New(context.TODO(), cfg.Blog)
+		if err != nil {
+			logging.Fatal("Failed to start the blog service", logging.Err(err))
+		}
+		blog.Register(s.GRPC)
+		s.Probe(blogservice.ServiceName, cfg.Blog.Mongo.PingInterval, blog.Ping)
+		go func() {
+			if err := blog.ServeFeeds(); err != nil {
+				logging.Fatal("Failed to serve feeds", logging.Err(err))
+			}
+		}()
+	}
+
+	go func() {
+		logging.Info("Serving", logging.F("services", cfg.Services), logging.F("addr", s.Addr()))
+		if err := s.Serve(); err != nil {
+			logging.Fatal("Failed to serve", logging.Err(err))
+		}
+	}()
+
+	// Block until Control C or SIGTERM, then drain the calls in flight
+	// before closing the connection with MongoDB they may still be using
+	logging.Info("Stopping the server", logging.F("signal", grpcserver.WaitForSignal()))
+	s.Shutdown(cfg.Server.ShutdownTimeout)
+
+	if blog != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := blog.Close(ctx); err != nil {
+			logging.Fatal("Error on disconnection with MongoDB", logging.Err(err))
+		}
+	}
+}
@@ -0,0 +1,340 @@
+// Package config loads the settings of the servers from a YAML file,
+// environment variables and command line flags.
+//
+// Settings are the exported fields of a struct, nested structs included,
+// named by the path of their yaml tags, sections tagged ",inline" sharing
+// the path of their parent. The values held by the struct when
+// it is loaded are the defaults, overridden in turn by the file, the
+// environment and the flags: tls.cert is read from the cert key of the tls
+// mapping, then from <PREFIX>_TLS_CERT and finally from -tls-cert.
+//
+// A flag tag overrides the name of the flag, a usage tag documents it and a
+// secret tag keeps the value out of the printed configuration. Settings
+// whose type cannot be written on a command line, such as maps, are only
+// read from the file.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Validator is implemented by configurations, and any of their sections,
+// checking their values once loaded
+type Validator interface {
+	Validate() error
+}
+
+// setting is a leaf of the configuration struct
+type setting struct {
+	path   string
+	flag   string
+	env    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Load fills cfg, a pointer to a struct holding the defaults, from the
+// file named by -config or <PREFIX>_CONFIG, the environment and args, in
+// that order of precedence, then validates it. Invalid flags and -help
+// exit the process like the flag package does.
+func Load(cfg interface{}, prefix string, args []string) error {
+	root := reflect.ValueOf(cfg)
+	if root.Kind() != reflect.Ptr || root.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: %T is not a pointer to a struct", cfg)
+	}
+	settings := collect(root.Elem(), "", prefix)
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	configFile := fs.String("config", "", fmt.Sprintf("YAML configuration file, also read from %v_CONFIG", prefix))
+	raw := map[string]string{}
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		fs.Var(&flagValue{setting: s, raw: raw}, s.flag, s.usage)
+	}
+	fs.Parse(args)
+
+	// File
+	if *configFile == "" {
+		*configFile = os.Getenv(prefix + "_CONFIG")
+	}
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return fmt.Errorf("config: %v", err)
+		}
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return fmt.Errorf("config: %v: %v", *configFile, err)
+		}
+	}
+
+	// Environment
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := set(s.value, v); err != nil {
+				return fmt.Errorf("config: %v: %v", s.env, err)
+			}
+		}
+	}
+
+	// Flags
+	for _, s := range settings {
+		if v, ok := raw[s.flag]; ok {
+			if err := set(s.value, v); err != nil {
+				return fmt.Errorf("config: -%v: %v", s.flag, err)
+			}
+		}
+	}
+
+	return validate(root, "")
+}
+
+// collect walks the fields of v, a struct, and returns its settings
+func collect(v reflect.Value, path, prefix string) []*setting {
+	var settings []*setting
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name, ok := fieldPath(field, path)
+		if !ok {
+			continue
+		}
+		value := v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			settings = append(settings, collect(value, name, prefix)...)
+			continue
+		}
+		s := &setting{
+			path:   name,
+			usage:  field.Tag.Get("usage"),
+			secret: field.Tag.Get("secret") == "true",
+			value:  value,
+		}
+		if settable(field.Type) {
+			s.flag = field.Tag.Get("flag")
+			if s.flag == "" {
+				s.flag = strings.NewReplacer(".", "-", "_", "-").Replace(name)
+			}
+			s.env = prefix + "_" + strings.ToUpper(strings.Replace(name, ".", "_", -1))
+		}
+		settings = append(settings, s)
+	}
+	return settings
+}
+
+// fieldPath returns the path of a field below path, the path itself for
+// sections inlined with the ",inline" yaml option, and false for ignored
+// fields
+func fieldPath(field reflect.StructField, path string) (string, bool) {
+	tag := strings.Split(field.Tag.Get("yaml"), ",")
+	if tag[0] == "-" {
+		return "", false
+	}
+	for _, opt := range tag[1:] {
+		if opt == "inline" {
+			return path, true
+		}
+	}
+	name := tag[0]
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	if path != "" {
+		name = path + "." + name
+	}
+	return name, true
+}
+
+// settable reports whether values of t can be parsed from a string
+func settable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint32, reflect.Uint64,
+		reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// set parses s into v. Durations use the time.ParseDuration syntax and
+// lists of strings are comma separated.
+func set(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+	return nil
+}
+
+// validate calls the Validate method of v and of every nested section,
+// innermost first
+func validate(v reflect.Value, path string) error {
+	elem := v
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	t := elem.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Type.Kind() != reflect.Struct {
+			continue
+		}
+		name, ok := fieldPath(field, path)
+		if !ok {
+			continue
+		}
+		if err := validate(elem.Field(i).Addr(), name); err != nil {
+			return err
+		}
+	}
+	if validator, ok := v.Interface().(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			if path == "" {
+				return fmt.Errorf("config: %v", err)
+			}
+			return fmt.Errorf("config: %v: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// Fprint writes the settings of cfg, one per line. Secrets and the
+// passwords of URLs are redacted.
+func Fprint(w io.Writer, cfg interface{}) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	for _, s := range collect(v, "", "") {
+		fmt.Fprintf(w, "%v = %v\n", s.path, display(s))
+	}
+}
+
+func display(s *setting) string {
+	if s.secret {
+		if s.value.IsZero() {
+			return `""`
+		}
+		return "REDACTED"
+	}
+	if s.value.Type() == durationType {
+		return time.Duration(s.value.Int()).String()
+	}
+	switch s.value.Kind() {
+	case reflect.String:
+		return strconv.Quote(redactURL(s.value.String()))
+	case reflect.Slice:
+		if s.value.Type().Elem().Kind() == reflect.String {
+			return strconv.Quote(strings.Join(s.value.Interface().([]string), ","))
+		}
+	}
+	return fmt.Sprintf("%v", s.value.Interface())
+}
+
+// redactURL hides the password of URLs such as MongoDB connection strings
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	return u.String()
+}
+
+// flagValue records the flags set on the command line, applied once the
+// file and the environment have been read
+type flagValue struct {
+	setting *setting
+	raw     map[string]string
+}
+
+// String returns the default shown by -help, empty for zero values
+func (f *flagValue) String() string {
+	if f == nil || f.setting == nil || f.setting.value.IsZero() {
+		return ""
+	}
+	if f.setting.value.Kind() == reflect.String {
+		return redactURL(f.setting.value.String())
+	}
+	return strings.Trim(display(f.setting), `"`)
+}
+
+func (f *flagValue) Set(s string) error {
+	if err := set(reflect.New(f.setting.value.Type()).Elem(), s); err != nil {
+		return err
+	}
+	f.raw[f.setting.flag] = s
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.setting.value.Kind() == reflect.Bool
+}
@@ -0,0 +1,220 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"greet/tlsutil"
+	"net"
+	"strings"
+	"time"
+)
+
+// Server holds the settings shared by the gRPC servers
+type Server struct {
+	Addr          string `yaml:"addr" usage:"gRPC listen address, without a host all interfaces are used with TLS and the loopback interface in plaintext"`
+	AllowInsecure bool   `yaml:"allow_insecure" usage:"Allow serving gRPC in plaintext on a non-loopback address"`
+	// On SIGINT or SIGTERM, calls in flight are given ShutdownTimeout to end
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" usage:"Time given to the calls in flight to end on shutdown before the server is forced to stop"`
+	MetricsAddr     string        `yaml:"metrics_addr" usage:"HTTP address serving Prometheus metrics on /metrics, empty to disable"`
+	Log             Log           `yaml:"log"`
+	Tracing         Tracing       `yaml:"tracing"`
+	TLS             TLS           `yaml:"tls"`
+	Auth            Auth          `yaml:"auth"`
+	RBAC            RBAC          `yaml:"rbac"`
+	RateLimit       RateLimit     `yaml:"rate_limit"`
+	LoadShedding    LoadShedding  `yaml:"load_shedding"`
+	Chaos           Chaos         `yaml:"chaos"`
+}
+
+// DefaultServer listens on addr, gives calls 30 seconds to end on shutdown,
+// logs info records as JSON, traces every call once an exporter is chosen,
+// allows each caller 100 calls per second and 16 open streams, and sheds
+// load when the handlers slow down
+func DefaultServer(addr string) Server {
+	return Server{
+		Addr:            addr,
+		ShutdownTimeout: 30 * time.Second,
+		Log:             Log{Level: "info", Format: "json"},
+		Tracing:         Tracing{Exporter: "none", SampleRatio: 1},
+		RateLimit:       RateLimit{Rate: 100, Burst: 200, MaxStreams: 16},
+		LoadShedding:    LoadShedding{Enabled: true, InitialLimit: 100, MinLimit: 10, MaxLimit: 1000, Tolerance: 2},
+	}
+}
+
+// Validate resolves an address without a host from the TLS mode, then
+// checks a server in plaintext only listens on a loopback address unless
+// insecure serving is explicitly allowed, since credentials would
+// otherwise cross the network in clear
+func (s *Server) Validate() error {
+	host, port, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return fmt.Errorf("addr: %v", err)
+	}
+	if host == "" {
+		host = "0.0.0.0"
+		if !s.TLS.Enabled() {
+			host = "127.0.0.1"
+		}
+		s.Addr = net.JoinHostPort(host, port)
+	}
+	if s.ShutdownTimeout < 0 {
+		return errors.New("shutdown_timeout cannot be negative")
+	}
+	if !s.TLS.Enabled() && !s.AllowInsecure && !tlsutil.IsLoopback(s.Addr) {
+		return fmt.Errorf("refusing to serve plaintext gRPC on %v, set tls.cert and tls.key or allow_insecure", s.Addr)
+	}
+	return nil
+}
+
+// TLS holds the certificate of a server and the CA bundle verifying the
+// certificates of its clients. Servers without a certificate use plaintext.
+type TLS struct {
+	Cert     string `yaml:"cert" usage:"Server certificate, empty to serve in plaintext"`
+	Key      string `yaml:"key" usage:"Private key of the server certificate"`
+	ClientCA string `yaml:"client_ca" usage:"CA bundle verifying client certificates, enables mutual TLS"`
+}
+
+// Enabled reports whether the server uses TLS
+func (t TLS) Enabled() bool {
+	return t.Cert != ""
+}
+
+// Validate checks the certificate comes with its key
+func (t TLS) Validate() error {
+	if t.Cert != "" && t.Key == "" {
+		return errors.New("key is required with cert")
+	}
+	if t.Cert == "" && t.Key != "" {
+		return errors.New("cert is required with key")
+	}
+	if t.ClientCA != "" && t.Cert == "" {
+		return errors.New("mutual TLS with client_ca requires cert and key")
+	}
+	return nil
+}
+
+// Auth selects how callers authenticate
+type Auth struct {
+	APIKeys     string `yaml:"api_keys" flag:"api-keys" usage:"API key store file, empty to disable API keys"`
+	JWKS        string `yaml:"jwks" flag:"jwks" usage:"JWKS file with the keys verifying bearer tokens, empty to disable bearer tokens"`
+	JWTAudience string `yaml:"jwt_audience" flag:"jwt-audience" usage:"Audience bearer tokens must be issued for"`
+	// Identity headers are only trusted without any other authenticator
+	TrustIdentityHeaders bool `yaml:"trust_identity_headers" flag:"trust-identity-headers" usage:"Without api_keys, jwks and client_ca, take caller identities from the x-user-id and x-user-roles metadata, only behind an authenticating proxy"`
+	Required             bool `yaml:"required" flag:"require-auth" usage:"Reject unauthenticated calls"`
+}
+
+// Log selects the records written to stderr and their format
+type Log struct {
+	Level  string   `yaml:"level" usage:"Lowest level of the records written: debug, info, warn or error"`
+	Format string   `yaml:"format" usage:"Format of the records: json or logfmt"`
+	Redact []string `yaml:"redact" usage:"Keys of the fields redacted on top of password, token, authorization and the like"`
+}
+
+// Validate checks the level and the format are known
+func (l Log) Validate() error {
+	switch strings.ToLower(l.Level) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		return fmt.Errorf("unknown level %q", l.Level)
+	}
+	switch l.Format {
+	case "json", "logfmt":
+	default:
+		return fmt.Errorf("unknown format %q", l.Format)
+	}
+	return nil
+}
+
+// Tracing selects where the spans of the calls are exported
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" usage:"Span exporter: none, stdout or otlp"`
+	Endpoint    string  `yaml:"endpoint" usage:"OTLP/HTTP traces endpoint, a collector on localhost when empty"`
+	ServiceName string  `yaml:"service_name" usage:"Service name reported with the spans"`
+	SampleRatio float64 `yaml:"sample_ratio" usage:"Fraction of the traces started by this server that are recorded"`
+}
+
+// Validate checks the exporter is known and the ratio is a fraction
+func (t Tracing) Validate() error {
+	switch t.Exporter {
+	case "", "none", "stdout", "otlp":
+	default:
+		return fmt.Errorf("unknown exporter %q", t.Exporter)
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return errors.New("sample_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// RBAC configures role based access control
+type RBAC struct {
+	Policy    string `yaml:"policy" usage:"RBAC policy file, empty to disable role based access control"`
+	AuditOnly bool   `yaml:"audit_only" usage:"Only log the calls the RBAC policy denies"`
+}
+
+// RateLimit bounds the calls and the concurrent streams of each caller
+type RateLimit struct {
+	Rate       float64 `yaml:"rate" usage:"Calls per second each caller may sustain, 0 for no limit"`
+	Burst      int     `yaml:"burst" usage:"Calls each caller may make at once above the rate"`
+	MaxStreams int     `yaml:"max_streams" usage:"Streaming calls each caller may keep open at once, 0 for no limit"`
+	// Methods replaces the limits of the methods matching its keys, full
+	// method names or patterns such as /blog.BlogService/*
+	Methods map[string]MethodLimit `yaml:"methods" usage:"Limits of the methods matching the keys, file only"`
+}
+
+// MethodLimit is the rate limit of some methods, zero values leave them
+// unlimited
+type MethodLimit struct {
+	Rate       float64 `yaml:"rate"`
+	Burst      int     `yaml:"burst"`
+	MaxStreams int     `yaml:"max_streams"`
+}
+
+// Validate checks every rate comes with a burst
+func (r RateLimit) Validate() error {
+	if r.Rate > 0 && r.Burst < 1 {
+		return errors.New("burst must be at least 1 with a rate")
+	}
+	for method, limit := range r.Methods {
+		if !strings.HasPrefix(method, "/") && method != "*" {
+			return fmt.Errorf("methods: %q is not a full method name such as /greet.GreetService/Greet", method)
+		}
+		if limit.Rate > 0 && limit.Burst < 1 {
+			return fmt.Errorf("methods: %v: burst must be at least 1 with a rate", method)
+		}
+	}
+	return nil
+}
+
+// LoadShedding bounds the unary calls handled at once with a limit adapting
+// to the latency of the handlers
+type LoadShedding struct {
+	Enabled      bool    `yaml:"enabled" usage:"Reject calls with Unavailable when the handlers slow down under load"`
+	InitialLimit int     `yaml:"initial_limit" usage:"Calls handled at once before the limit adapts"`
+	MinLimit     int     `yaml:"min_limit" usage:"Lowest limit of the calls handled at once"`
+	MaxLimit     int     `yaml:"max_limit" usage:"Highest limit of the calls handled at once"`
+	Tolerance    float64 `yaml:"tolerance" usage:"How many times slower than without load calls may get before the limit shrinks"`
+}
+
+// Validate checks, when enabled, the limits are ordered and the tolerance
+// allows some slowdown
+func (l LoadShedding) Validate() error {
+	if !l.Enabled {
+		return nil
+	}
+	if l.MinLimit < 1 || l.MaxLimit < l.MinLimit {
+		return errors.New("limits must satisfy 1 <= min_limit <= max_limit")
+	}
+	if l.InitialLimit < l.MinLimit || l.InitialLimit > l.MaxLimit {
+		return errors.New("initial_limit must be between min_limit and max_limit")
+	}
+	if l.Tolerance <= 1 {
+		return errors.New("tolerance must be above 1")
+	}
+	return nil
+}
+
+// Chaos enables the injection of faults, for resilience testing only
+type Chaos struct {
+	Enabled bool `yaml:"enabled" usage:"Serve the chaos.ChaosService admin RPC injecting faults in the calls, for resilience testing only"`
+}
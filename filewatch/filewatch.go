@@ -0,0 +1,75 @@
+// Package filewatch polls files and calls back when they change.
+//
+// Polling the modification time and size of the files works the same on
+// every platform and also follows the symlink swaps used to update
+// mounted secrets, at the cost of noticing changes after up to one interval.
+package filewatch
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func stat(path string) fileState {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: fi.ModTime(), size: fi.Size(), exists: true}
+}
+
+// Watcher calls a function each time one of its files changes
+type Watcher struct {
+	paths    []string
+	onChange func()
+	done     chan struct{}
+	once     sync.Once
+}
+
+// New starts watching paths every interval. onChange is called from the
+// watcher goroutine once per poll in which any of the files changed,
+// including when a file is created or removed.
+func New(interval time.Duration, onChange func(), paths ...string) *Watcher {
+	w := &Watcher{paths: paths, onChange: onChange, done: make(chan struct{})}
+	states := make([]fileState, len(paths))
+	for i, p := range paths {
+		states[i] = stat(p)
+	}
+	go w.run(interval, states)
+	return w
+}
+
+func (w *Watcher) run(interval time.Duration, states []fileState) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		changed := false
+		for i, p := range w.paths {
+			if s := stat(p); s != states[i] {
+				states[i] = s
+				changed = true
+			}
+		}
+		if changed {
+			w.onChange()
+		}
+	}
+}
+
+// Close stops the watcher, onChange is not called after Close returns
+// unless it was already running
+func (w *Watcher) Close() {
+	w.once.Do(func() { close(w.done) })
+}
@@ -2,12 +2,20 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"greet/auth"
 	"greet/greet/greetpb"
+	"greet/grpcclient"
+	"greet/logging"
+	"greet/tlsutil"
+	"greet/tracing"
 	"io"
 	"log"
+	"os"
 	"time"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
@@ -16,6 +24,20 @@ import (
 
 func main() {
 
+	certFile := flag.String("cert", "", "Client certificate presented to servers requiring mutual TLS")
+	keyFile := flag.String("key", "", "Private key of the client certificate")
+	traceExporter := flag.String("trace", "none", "Exporter of the call traces: none, stdout or otlp")
+	traceEndpoint := flag.String("trace-endpoint", "", "OTLP/HTTP traces endpoint, defaults to a local collector")
+	flag.Parse()
+
+	tracer, err := tracing.Setup(*traceExporter, *traceEndpoint, tracing.Options{ServiceName: "greet-client", SampleRatio: 1})
+	if err != nil {
+		log.Fatalf("Error while setting up tracing : %v", err)
+	}
+	if tracer != nil {
+		defer tracer.Shutdown(context.Background())
+	}
+
 	fmt.Println("Hello I am Client")
 
 	// With SSL
@@ -23,16 +45,34 @@ func main() {
 	opts := grpc.WithInsecure()
 
 	if tls {
-		certFile := "greet/ssl/ca.crt" // Certificate Authority
-		creds, sslErr := credentials.NewClientTLSFromFile(certFile, "")
+		caFile := "greet/ssl/ca.crt" // Certificate Authority
+		certs, sslErr := tlsutil.NewReloader(*certFile, *keyFile, caFile)
 		if sslErr != nil {
-			log.Fatalf("Error while loading CA trust certificates : %v", sslErr)
+			log.Fatalf("Error while loading CA trust certificates, generate them with go run ./cmd/certgen : %v", sslErr)
 			return
 		}
-		opts = grpc.WithTransportCredentials(creds)
+		defer certs.Watch(10 * time.Second).Close()
+		opts = grpc.WithTransportCredentials(credentials.NewTLS(certs.ClientConfig("")))
 	}
 
-	conn, err := grpc.Dial("localhost:50051", opts)
+	dialOpts := []grpc.DialOption{opts}
+
+	// Authenticate with an API key or a bearer token when one is provided
+	if key := os.Getenv("GREET_API_KEY"); key != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(auth.APIKey{Key: key, AllowInsecure: !tls}))
+	}
+	if token := os.Getenv("GREET_TOKEN"); token != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(auth.BearerToken{Token: token, AllowInsecure: !tls}))
+	}
+
+	// Send the trace context and a request ID with every call, retried or
+	// hedged by the client policy
+	dialOpts = append(dialOpts,
+		grpc.WithChainUnaryInterceptor(otelgrpc.UnaryClientInterceptor(), logging.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(otelgrpc.StreamClientInterceptor(), logging.StreamClientInterceptor()),
+	)
+
+	conn, err := grpcclient.Dial("localhost:50051", grpcclient.GreetPolicy(), dialOpts...)
 	if err != nil {
 		log.Fatalf("Could not conect: %v", err)
 	}
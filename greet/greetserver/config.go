@@ -0,0 +1,20 @@
+package main
+
+import (
+	"greet/config"
+)
+
+// serverConfig holds the settings of the greet server, read from the file
+// named by -config or GREET_CONFIG, GREET_* environment variables and flags
+type serverConfig struct {
+	Server config.Server `yaml:",inline"`
+}
+
+func defaultConfig() *serverConfig {
+	cfg := &serverConfig{Server: config.DefaultServer("0.0.0.0:50051")}
+	cfg.Server.TLS.Cert = "greet/ssl/server.crt"
+	cfg.Server.TLS.Key = "greet/ssl/server.pem"
+	cfg.Server.MetricsAddr = "0.0.0.0:9091"
+	cfg.Server.Tracing.ServiceName = "greet-server"
+	return cfg
+}
@@ -1,240 +1,47 @@
 package main
 
 import (
-	"context"
 	"fmt"
-	"greet/greet/greetpb"
-	"io"
-	"log"
-	"math"
-	"net"
-	"strconv"
-	"time"
-
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/status"
+	"greet/config"
+	"greet/greet/greetservice"
+	"greet/grpcserver"
+	"greet/logging"
+	"os"
 )
 
-type server struct{}
-
-func (*server) Greet(ctx context.Context, req *greetpb.GreetRequest) (*greetpb.GreetResponse, error) {
-	fmt.Printf("Greet function was invoked with %v", req)
-	firstName := req.GetGreeting().GetFirstName()
-	result := "Hello " + firstName
-	res := &greetpb.GreetResponse{
-		Result: result,
-	}
-	return res, nil
-}
-
-func (*server) CalculateSum(ctx context.Context, req *greetpb.CalculateRequest) (*greetpb.CalculateResponse, error) {
-	fmt.Printf("Calculate Sum function was invoked with %v", req)
-	x := req.GetCalvalue().GetX()
-	y := req.GetCalvalue().GetY()
-	sum := x + y
-	result := fmt.Sprintf("Sum of %v and %v is %v", x, y, sum)
-	res := &greetpb.CalculateResponse{
-		Result: result,
-	}
-	return res, nil
-}
-
-func (*server) GreetManyTimes(req *greetpb.GreetManyTimesRequest, stream greetpb.GreetService_GreetManyTimesServer) error {
-	fmt.Printf("Greet Stream Server function was invoked with %v", req)
-	firstName := req.GetGreeting().GetFirstName()
-	for i := 0; i < 10; i++ {
-		result := "Hello " + firstName + " number " + strconv.Itoa(i)
-		res := &greetpb.GreetManyTimesResponse{
-			Result: result,
-		}
-		stream.Send(res)
-		time.Sleep(1000 * time.Millisecond)
-	}
-	return nil
-}
-
-func (*server) PrimeNumberDecomposition(req *greetpb.PrimeNumberDecompositionRequest, stream greetpb.GreetService_PrimeNumberDecompositionServer) error {
-
-	fmt.Printf("Prime Decomposition received RCP %v", req)
-
-	number := req.GetNumber()
-	divisor := int64(2)
-
-	for number > 1 {
-		if number%divisor == 0 {
-			stream.Send(&greetpb.PrimeNumberDecompositionResponse{
-				PrimeFactor: divisor,
-			})
-			number = number / divisor
-		} else {
-			divisor++
-		}
-	}
-	return nil
-}
-
-func (*server) LongGreet(stream greetpb.GreetService_LongGreetServer) error {
-
-	fmt.Printf("LongGreet function was invoked with a streaming request")
-
-	result := ""
-	for {
-		req, err := stream.Recv()
-		if err == io.EOF {
-			// We have finished reading the client stream
-			return stream.SendAndClose(&greetpb.LongGreetResponse{
-				Result: result,
-			})
-		}
-		if err != nil {
-			log.Fatalf("Error while reading client stream : %v", err)
-		}
-
-		firstName := req.GetGreeting().GetFirstName()
-		result += "Hello " + firstName + "! "
-	}
-}
-
-func (*server) ComputeAverage(stream greetpb.GreetService_ComputeAverageServer) error {
-
-	fmt.Printf("Compute Average function was invoked with a streaming request")
-
-	sum := int32(0)
-	count := 0
-
-	for {
-		req, err := stream.Recv()
-		if err == io.EOF {
-			// We have finished reading the client stream
-			average := float64(sum) / float64(count)
-			return stream.SendAndClose(&greetpb.ComputeAverageResponse{
-				Average: average,
-			})
-		}
-		if err != nil {
-			log.Fatalf("Error while reading client stream : %v", err)
-		}
-
-		sum += req.GetNumber()
-		count++
-	}
-}
-
-func (*server) GreetEveryone(stream greetpb.GreetService_GreetEveryoneServer) error {
-
-	fmt.Printf("Greet Everyone func is invokes\n")
-
-	for {
-		req, err := stream.Recv()
-		if err == io.EOF {
-			return nil
-		}
-		if err != nil {
-			log.Fatalf("Error while reading client stream : %v", err)
-			return err
-		}
-
-		firstName := req.GetGreeting().GetFirstName()
-		result := "Hello " + firstName + "! "
-
-		sendErr := stream.Send(&greetpb.GreetEveryoneResponse{
-			Result: result,
-		})
-		if sendErr != nil {
-			log.Fatalf("Error while sending data to client : %v", err)
-			return err
-		}
-	}
-
-}
+func main() {
 
-func (*server) FindMaximum(stream greetpb.GreetService_FindMaximumServer) error {
-	maximum := int32(0)
-	for {
-		req, err := stream.Recv()
-		if err == io.EOF {
-			return nil
-		}
-		if err != nil {
-			log.Fatalf("Error while reading client stream: %v", err)
-			return err
-		}
-		number := req.GetNumber()
-		if number > maximum {
-			maximum = number
-			sendErr := stream.Send(&greetpb.FindMaximumResponse{
-				Maximum: maximum,
-			})
-			if sendErr != nil {
-				log.Fatalf("Error while sending data to client stream: %v", err)
-				return err
-			}
-		}
+	cfg := defaultConfig()
+	if err := config.Load(cfg, "GREET", os.Args[1:]); err != nil {
+		logging.Fatal("Invalid configuration", logging.Err(err))
 	}
-}
-
-func (*server) SquareRoot(ctx context.Context, req *greetpb.SquareRootRequest) (*greetpb.SquareRootResponse, error) {
-	fmt.Println("Received SquareRoot RPC")
-	number := req.GetNumber()
-	if number < 0 {
-		return nil, status.Errorf(
-			codes.InvalidArgument,
-			fmt.Sprintf("Received a negative number : %v", number),
-		)
+	if err := logging.Setup(cfg.Server.Log.Level, cfg.Server.Log.Format, cfg.Server.Log.Redact); err != nil {
+		logging.Fatal("Invalid log configuration", logging.Err(err))
 	}
-	return &greetpb.SquareRootResponse{
-		NumberRoot: math.Sqrt(float64(number)),
-	}, nil
-}
 
-func (*server) GreetWithDeadline(ctx context.Context, req *greetpb.GreetWithDeadlineRequest) (*greetpb.GreetWithDeadlineResponse, error) {
-	fmt.Printf("GreetWithDeadline function was invoked with %v\n", req)
-	for i := 0; i < 3; i++ {
-		if ctx.Err() == context.Canceled {
-			// the client cancelled the request
-			fmt.Println("The client cancelled the request !")
-			return nil, status.Error(codes.DeadlineExceeded, "The client cancelled the request")
+	// The default certificates are generated locally rather than committed
+	for _, path := range []string{cfg.Server.TLS.Cert, cfg.Server.TLS.Key} {
+		if _, err := os.Stat(path); path != "" && os.IsNotExist(err) {
+			logging.Fatal("TLS certificate not found, generate it with go run ./cmd/certgen -out greet/ssl -hosts localhost,127.0.0.1", logging.F("path", path))
 		}
-		time.Sleep(1 * time.Second)
-	}
-	firstName := req.GetGreeting().GetFirstName()
-	result := "Hello " + firstName
-	res := &greetpb.GreetWithDeadlineResponse{
-		Result: result,
 	}
-	return res, nil
-}
-
-func main() {
 
-	fmt.Println("hello")
+	fmt.Println("Effective configuration")
+	config.Fprint(os.Stdout, cfg)
 
-	lis, err := net.Listen("tcp", "0.0.0.0:50051")
+	s, err := grpcserver.New(cfg.Server)
 	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+		logging.Fatal("Failed to start", logging.Err(err))
 	}
+	greetservice.Register(s.GRPC)
 
-	opts := []grpc.ServerOption{} // If not set then bydefault it will be nil
-
-	// With SSL
-	tls := true
-	if tls {
-		certFile := "greet/ssl/server.crt"
-		keyFile := "greet/ssl/server.pem"
-		creds, sslErr := credentials.NewServerTLSFromFile(certFile, keyFile)
-		if sslErr != nil {
-			log.Fatalf("Failed loading certificates : %v", sslErr)
-			return
+	go func() {
+		if err := s.Serve(); err != nil {
+			logging.Fatal("Failed to serve", logging.Err(err))
 		}
-		opts = append(opts, grpc.Creds(creds))
-	}
-
-	s := grpc.NewServer(opts...)
-	greetpb.RegisterGreetServiceServer(s, &server{})
+	}()
 
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("Failed to serve: %v", err)
-	}
+	// Block until Control C or SIGTERM, then drain the calls in flight
+	logging.Info("Stopping the server", logging.F("signal", grpcserver.WaitForSignal()))
+	s.Shutdown(cfg.Server.ShutdownTimeout)
 }
@@ -0,0 +1,202 @@
+// Package greetservice implements the greet.GreetService gRPC service.
+package greetservice
+
+import (
+	"context"
+	"fmt"
+	"greet/greet/greetpb"
+	"greet/grpcserver"
+	"greet/logging"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements greetpb.GreetServiceServer
+type Server struct{}
+
+// Register registers a greet service on s
+func Register(s *grpc.Server) {
+	greetpb.RegisterGreetServiceServer(s, &Server{})
+}
+
+func (*Server) Greet(ctx context.Context, req *greetpb.GreetRequest) (*greetpb.GreetResponse, error) {
+	firstName := req.GetGreeting().GetFirstName()
+	result := "Hello " + firstName
+	res := &greetpb.GreetResponse{
+		Result: result,
+	}
+	return res, nil
+}
+
+func (*Server) CalculateSum(ctx context.Context, req *greetpb.CalculateRequest) (*greetpb.CalculateResponse, error) {
+	x := req.GetCalvalue().GetX()
+	y := req.GetCalvalue().GetY()
+	sum := x + y
+	result := fmt.Sprintf("Sum of %v and %v is %v", x, y, sum)
+	res := &greetpb.CalculateResponse{
+		Result: result,
+	}
+	return res, nil
+}
+
+func (*Server) GreetManyTimes(req *greetpb.GreetManyTimesRequest, stream greetpb.GreetService_GreetManyTimesServer) error {
+	firstName := req.GetGreeting().GetFirstName()
+	for i := 0; i < 10; i++ {
+		result := "Hello " + firstName + " number " + strconv.Itoa(i)
+		res := &greetpb.GreetManyTimesResponse{
+			Result: result,
+		}
+		if err := stream.Send(res); err != nil {
+			return grpcserver.StreamError(err, "Error while sending data to client stream")
+		}
+		select {
+		case <-time.After(1000 * time.Millisecond):
+		case <-stream.Context().Done():
+			return grpcserver.StreamError(stream.Context().Err(), "Client stream ended")
+		}
+	}
+	return nil
+}
+
+func (*Server) PrimeNumberDecomposition(req *greetpb.PrimeNumberDecompositionRequest, stream greetpb.GreetService_PrimeNumberDecompositionServer) error {
+	number := req.GetNumber()
+	divisor := int64(2)
+
+	for number > 1 {
+		if number%divisor == 0 {
+			err := stream.Send(&greetpb.PrimeNumberDecompositionResponse{
+				PrimeFactor: divisor,
+			})
+			if err != nil {
+				return grpcserver.StreamError(err, "Error while sending data to client stream")
+			}
+			number = number / divisor
+		} else {
+			divisor++
+		}
+	}
+	return nil
+}
+
+func (*Server) LongGreet(stream greetpb.GreetService_LongGreetServer) error {
+	result := ""
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			// We have finished reading the client stream
+			return stream.SendAndClose(&greetpb.LongGreetResponse{
+				Result: result,
+			})
+		}
+		if err != nil {
+			return grpcserver.StreamError(err, "Error while reading client stream")
+		}
+
+		firstName := req.GetGreeting().GetFirstName()
+		result += "Hello " + firstName + "! "
+	}
+}
+
+func (*Server) ComputeAverage(stream greetpb.GreetService_ComputeAverageServer) error {
+	sum := int32(0)
+	count := 0
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			// We have finished reading the client stream
+			average := float64(sum) / float64(count)
+			return stream.SendAndClose(&greetpb.ComputeAverageResponse{
+				Average: average,
+			})
+		}
+		if err != nil {
+			return grpcserver.StreamError(err, "Error while reading client stream")
+		}
+
+		sum += req.GetNumber()
+		count++
+	}
+}
+
+func (*Server) GreetEveryone(stream greetpb.GreetService_GreetEveryoneServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return grpcserver.StreamError(err, "Error while reading client stream")
+		}
+
+		firstName := req.GetGreeting().GetFirstName()
+		result := "Hello " + firstName + "! "
+
+		sendErr := stream.Send(&greetpb.GreetEveryoneResponse{
+			Result: result,
+		})
+		if sendErr != nil {
+			return grpcserver.StreamError(sendErr, "Error while sending data to client")
+		}
+	}
+
+}
+
+func (*Server) FindMaximum(stream greetpb.GreetService_FindMaximumServer) error {
+	maximum := int32(0)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return grpcserver.StreamError(err, "Error while reading client stream")
+		}
+		number := req.GetNumber()
+		if number > maximum {
+			maximum = number
+			sendErr := stream.Send(&greetpb.FindMaximumResponse{
+				Maximum: maximum,
+			})
+			if sendErr != nil {
+				return grpcserver.StreamError(sendErr, "Error while sending data to client stream")
+			}
+		}
+	}
+}
+
+func (*Server) SquareRoot(ctx context.Context, req *greetpb.SquareRootRequest) (*greetpb.SquareRootResponse, error) {
+	number := req.GetNumber()
+	if number < 0 {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			fmt.Sprintf("Received a negative number : %v", number),
+		)
+	}
+	return &greetpb.SquareRootResponse{
+		NumberRoot: math.Sqrt(float64(number)),
+	}, nil
+}
+
+func (*Server) GreetWithDeadline(ctx context.Context, req *greetpb.GreetWithDeadlineRequest) (*greetpb.GreetWithDeadlineResponse, error) {
+	for i := 0; i < 3; i++ {
+		if ctx.Err() == context.Canceled {
+			// the client cancelled the request
+			logging.FromContext(ctx).Info("The client cancelled the request")
+			return nil, status.Error(codes.DeadlineExceeded, "The client cancelled the request")
+		}
+		time.Sleep(1 * time.Second)
+	}
+	firstName := req.GetGreeting().GetFirstName()
+	result := "Hello " + firstName
+	res := &greetpb.GreetWithDeadlineResponse{
+		Result: result,
+	}
+	return res, nil
+}
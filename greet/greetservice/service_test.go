@@ -0,0 +1,190 @@
+package greetservice
+
+import (
+	"context"
+	"greet/greet/greetpb"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// handled is what a stream handler returned
+type handled struct {
+	method string
+	err    error
+}
+
+// signallingStream signals once the handler received its first message
+type signallingStream struct {
+	grpc.ServerStream
+	once     sync.Once
+	received chan<- struct{}
+}
+
+func (s *signallingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.once.Do(func() { s.received <- struct{}{} })
+	}
+	return err
+}
+
+// startServer serves the greet service over an in-memory listener. The
+// returned channels report the first message received by each streaming
+// handler and what the handler returned.
+func startServer(t *testing.T) (greetpb.GreetServiceClient, <-chan struct{}, <-chan handled) {
+	received := make(chan struct{}, 1)
+	results := make(chan handled, 1)
+	s := grpc.NewServer(grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, &signallingStream{ServerStream: ss, received: received})
+		results <- handled{info.FullMethod, err}
+		return err
+	}))
+	Register(s)
+
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return greetpb.NewGreetServiceClient(conn), received, results
+}
+
+func wait(t *testing.T, what string, ch <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %v", what)
+	}
+}
+
+func TestClientStreamsCancelledPartway(t *testing.T) {
+	greeting := &greetpb.Greeting{FirstName: "Deepak"}
+	tests := []struct {
+		method string
+		// open starts a stream and sends its first message
+		open func(ctx context.Context, c greetpb.GreetServiceClient) error
+	}{
+		{"/greet.GreetService/LongGreet", func(ctx context.Context, c greetpb.GreetServiceClient) error {
+			stream, err := c.LongGreet(ctx)
+			if err != nil {
+				return err
+			}
+			return stream.Send(&greetpb.LongGreetRequest{Greeting: greeting})
+		}},
+		{"/greet.GreetService/ComputeAverage", func(ctx context.Context, c greetpb.GreetServiceClient) error {
+			stream, err := c.ComputeAverage(ctx)
+			if err != nil {
+				return err
+			}
+			return stream.Send(&greetpb.ComputeAverageRequest{Number: 3})
+		}},
+		{"/greet.GreetService/GreetEveryone", func(ctx context.Context, c greetpb.GreetServiceClient) error {
+			stream, err := c.GreetEveryone(ctx)
+			if err != nil {
+				return err
+			}
+			return stream.Send(&greetpb.GreetEveryoneRequest{Greeting: greeting})
+		}},
+		{"/greet.GreetService/FindMaximum", func(ctx context.Context, c greetpb.GreetServiceClient) error {
+			stream, err := c.FindMaximum(ctx)
+			if err != nil {
+				return err
+			}
+			return stream.Send(&greetpb.FindMaximumRequest{Number: 7})
+		}},
+	}
+
+	c, received, results := startServer(t)
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := tt.open(ctx, c); err != nil {
+				t.Fatalf("open stream: %v", err)
+			}
+			wait(t, "the first message", received)
+
+			// The client goes away in the middle of the stream
+			cancel()
+			var res handled
+			select {
+			case res = <-results:
+			case <-time.After(5 * time.Second):
+				t.Fatal("handler did not return")
+			}
+			if res.method != tt.method {
+				t.Fatalf("handler of %v returned, want %v", res.method, tt.method)
+			}
+			st, ok := status.FromError(res.err)
+			if !ok {
+				t.Fatalf("handler returned %v, want a status error", res.err)
+			}
+			if st.Code() != codes.Canceled {
+				t.Errorf("handler returned %v, want %v", st.Code(), codes.Canceled)
+			}
+
+			// The server keeps serving other calls
+			greetCtx, greetCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer greetCancel()
+			resp, err := c.Greet(greetCtx, &greetpb.GreetRequest{Greeting: greeting})
+			if err != nil {
+				t.Fatalf("Greet after the cancelled stream: %v", err)
+			}
+			if resp.GetResult() == "" {
+				t.Error("Greet after the cancelled stream returned an empty result")
+			}
+		})
+	}
+}
+
+func TestClientStreamCompletesAfterCancellations(t *testing.T) {
+	c, received, results := startServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := c.ComputeAverage(ctx)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	if err := stream.Send(&greetpb.ComputeAverageRequest{Number: 1}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	wait(t, "the first message", received)
+	cancel()
+	<-results
+
+	stream, err = c.ComputeAverage(context.Background())
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	for _, n := range []int32{1, 2, 3, 4} {
+		if err := stream.Send(&greetpb.ComputeAverageRequest{Number: n}); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	if res.GetAverage() != 2.5 {
+		t.Errorf("average = %v, want 2.5", res.GetAverage())
+	}
+	wait(t, "the first message", received)
+	if r := <-results; r.err != nil {
+		t.Errorf("handler returned %v", r.err)
+	}
+}
@@ -1,3 +1,10 @@
+
+# The certificates and private keys are not part of the repository, each
+# developer generates their own before running the greet server and client:
+#   go run ./cmd/certgen -out greet/ssl -hosts localhost,127.0.0.1
+# certgen also creates a client certificate (client.crt, client.pem) for mutual TLS.
+# Never commit them: anyone holding ca.key can issue certificates the servers trust.
+# The openssl steps below are kept for reference.
 # Summary
 # Private Files : ca.key, server.key, server.pem, server.crt
 # Share Files : ca.crt (needed by the client), server.csr (needed by the CA)
@@ -0,0 +1,257 @@
+// Package grpcclient dials the greet and blog servers with a policy making
+// the unary calls resilient to transient failures: a default deadline for
+// the calls without one, retries with exponential backoff and jitter for the
+// calls safe to repeat, and hedging for the reads worth racing.
+//
+// Policies follow the method configs of the gRPC service config. Their
+// retries are handed to grpc-go as the default service config of the
+// connections, and follow the pushback the servers send along with their
+// RetryInfo delays. grpc-go supports neither hedging nor deadlines for the
+// unary calls only, so those are applied by an interceptor, which also
+// gives the attempts of a call a shared idempotency key. Streaming calls are
+// neither retried nor given a deadline, their callers decide how long they
+// last.
+package grpcclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// maxAttempts bounds the attempts of a call, as the gRPC service config does
+const maxAttempts = 5
+
+// RetryPolicy retries the calls failing with a transient error
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts of a call, the first included
+	MaxAttempts int
+	// InitialBackoff, MaxBackoff and BackoffMultiplier bound the random
+	// wait before each retry, which grows exponentially
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	// RetryableCodes are the codes of the failures worth retrying
+	RetryableCodes []codes.Code
+	// IdempotencyKey sends an idempotency key with the call, the same for
+	// all its attempts, for the calls only safe to retry along with one
+	IdempotencyKey bool
+}
+
+// HedgingPolicy sends several attempts of a call without waiting for the
+// previous ones to fail, and keeps the first to succeed
+type HedgingPolicy struct {
+	// MaxAttempts is the number of attempts of a call, the first included
+	MaxAttempts int
+	// HedgingDelay is the wait before sending the next attempt
+	HedgingDelay time.Duration
+	// NonFatalCodes are the codes of the failures sending the next attempt
+	// at once, any other one fails the call
+	NonFatalCodes []codes.Code
+}
+
+// MethodPolicy configures the calls of a method
+type MethodPolicy struct {
+	// Timeout is the deadline of the calls made without one, none when zero
+	Timeout time.Duration
+	// Retry and Hedging are exclusive, calls are sent once without them
+	Retry   *RetryPolicy
+	Hedging *HedgingPolicy
+}
+
+// Policy configures the calls of a client
+type Policy struct {
+	// Default applies to the methods missing from Methods
+	Default MethodPolicy
+	// Methods are keyed by full method name, such as
+	// /greet.GreetService/Greet, or by service, such as
+	// /greet.GreetService/*
+	Methods map[string]MethodPolicy
+}
+
+// method returns the policy of fullMethod
+func (p Policy) method(fullMethod string) MethodPolicy {
+	if mp, ok := p.Methods[fullMethod]; ok {
+		return mp
+	}
+	if i := strings.LastIndex(fullMethod, "/"); i > 0 {
+		if mp, ok := p.Methods[fullMethod[:i+1]+"*"]; ok {
+			return mp
+		}
+	}
+	return p.Default
+}
+
+// Validate checks the policy
+func (p Policy) Validate() error {
+	if err := p.Default.validate(); err != nil {
+		return fmt.Errorf("default: %v", err)
+	}
+	for name, mp := range p.Methods {
+		if !strings.HasPrefix(name, "/") || strings.Count(name, "/") != 2 {
+			return fmt.Errorf("%q is not a full method name such as /greet.GreetService/Greet", name)
+		}
+		if err := mp.validate(); err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (mp MethodPolicy) validate() error {
+	if mp.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	if mp.Retry != nil && mp.Hedging != nil {
+		return fmt.Errorf("retry and hedging are exclusive")
+	}
+	if r := mp.Retry; r != nil {
+		if r.MaxAttempts < 2 || r.MaxAttempts > maxAttempts {
+			return fmt.Errorf("retry attempts must be between 2 and %v", maxAttempts)
+		}
+		if r.InitialBackoff <= 0 || r.MaxBackoff < r.InitialBackoff || r.BackoffMultiplier < 1 {
+			return fmt.Errorf("retry backoff must be positive, with a multiplier of at least 1 and a max of at least the initial backoff")
+		}
+		if len(r.RetryableCodes) == 0 {
+			return fmt.Errorf("retry needs retryable codes")
+		}
+	}
+	if h := mp.Hedging; h != nil {
+		if h.MaxAttempts < 2 || h.MaxAttempts > maxAttempts {
+			return fmt.Errorf("hedging attempts must be between 2 and %v", maxAttempts)
+		}
+		if h.HedgingDelay < 0 {
+			return fmt.Errorf("hedging delay cannot be negative")
+		}
+	}
+	return nil
+}
+
+// Service config of the retry policies, as documented in
+// https://github.com/grpc/grpc/blob/master/doc/service_config.md
+type serviceConfig struct {
+	MethodConfig []methodConfig `json:"methodConfig"`
+}
+
+type methodConfig struct {
+	Name        []methodName       `json:"name"`
+	RetryPolicy *retryPolicyConfig `json:"retryPolicy"`
+}
+
+type methodName struct {
+	Service string `json:"service,omitempty"`
+	Method  string `json:"method,omitempty"`
+}
+
+type retryPolicyConfig struct {
+	MaxAttempts          int      `json:"maxAttempts"`
+	InitialBackoff       string   `json:"initialBackoff"`
+	MaxBackoff           string   `json:"maxBackoff"`
+	BackoffMultiplier    float64  `json:"backoffMultiplier"`
+	RetryableStatusCodes []string `json:"retryableStatusCodes"`
+}
+
+// configName returns the service config name of a method, or of a service
+// for a /service/* name
+func configName(name string) methodName {
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	if parts[1] == "*" {
+		return methodName{Service: parts[0]}
+	}
+	return methodName{Service: parts[0], Method: parts[1]}
+}
+
+// configDuration formats d as the service config does, in seconds
+func configDuration(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+// configCode returns the name of code in the service config, such as
+// RESOURCE_EXHAUSTED
+func configCode(code codes.Code) string {
+	var b strings.Builder
+	for i, r := range code.String() {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+func (r *RetryPolicy) config() *retryPolicyConfig {
+	c := &retryPolicyConfig{
+		MaxAttempts:       r.MaxAttempts,
+		InitialBackoff:    configDuration(r.InitialBackoff),
+		MaxBackoff:        configDuration(r.MaxBackoff),
+		BackoffMultiplier: r.BackoffMultiplier,
+	}
+	for _, code := range r.RetryableCodes {
+		c.RetryableStatusCodes = append(c.RetryableStatusCodes, configCode(code))
+	}
+	return c
+}
+
+// ServiceConfig returns the service config applying the retry policies,
+// which UnaryClientInterceptor leaves to grpc-go
+func (p Policy) ServiceConfig() string {
+	var sc serviceConfig
+	if p.Default.Retry != nil {
+		sc.MethodConfig = append(sc.MethodConfig, methodConfig{Name: []methodName{{}}, RetryPolicy: p.Default.Retry.config()})
+	}
+	for name, mp := range p.Methods {
+		if mp.Retry != nil {
+			sc.MethodConfig = append(sc.MethodConfig, methodConfig{Name: []methodName{configName(name)}, RetryPolicy: mp.Retry.config()})
+		}
+	}
+	// Methods are sorted to keep the config stable between runs
+	sort.Slice(sc.MethodConfig, func(i, j int) bool {
+		a, b := sc.MethodConfig[i].Name[0], sc.MethodConfig[j].Name[0]
+		return a.Service+"/"+a.Method < b.Service+"/"+b.Method
+	})
+	data, _ := json.Marshal(sc)
+	return string(data)
+}
+
+// Dial connects to target, applying policy to the unary calls before the
+// interceptors of opts
+func Dial(target string, policy Policy, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid client policy: %v", err)
+	}
+	opts = append([]grpc.DialOption{
+		grpc.WithDefaultServiceConfig(policy.ServiceConfig()),
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor(policy)),
+	}, opts...)
+	return grpc.Dial(target, opts...)
+}
+
+// UnaryClientInterceptor applies the deadlines and the hedging of policy
+// to the unary calls, while grpc-go retries them along the service config
+// returned by ServiceConfig. It must run before the tracing and logging
+// interceptors so that each hedged attempt gets its own span.
+func UnaryClientInterceptor(policy Policy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		mp := policy.method(method)
+		if _, ok := ctx.Deadline(); !ok && mp.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, mp.Timeout)
+			defer cancel()
+		}
+		switch {
+		case mp.Retry != nil:
+			return retry(ctx, mp.Retry, method, req, reply, cc, invoker, opts)
+		case mp.Hedging != nil:
+			return hedge(ctx, mp.Hedging, method, req, reply, cc, invoker, opts)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
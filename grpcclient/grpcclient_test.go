@@ -0,0 +1,176 @@
+package grpcclient
+
+import (
+	"context"
+	"encoding/json"
+	"greet/greet/greetpb"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// flakyGreeter rejects the first failures calls to Greet with
+// ResourceExhausted, asking to wait pushback, and records every attempt
+type flakyGreeter struct {
+	greetpb.UnimplementedGreetServiceServer
+	failures int
+	pushback time.Duration
+
+	mu       sync.Mutex
+	attempts []time.Time
+	keys     []string
+}
+
+func (g *flakyGreeter) Greet(ctx context.Context, req *greetpb.GreetRequest) (*greetpb.GreetResponse, error) {
+	g.mu.Lock()
+	g.attempts = append(g.attempts, time.Now())
+	md, _ := metadata.FromIncomingContext(ctx)
+	g.keys = append(g.keys, md.Get(IdempotencyKeyHeader)...)
+	attempt := len(g.attempts)
+	g.mu.Unlock()
+	if attempt > g.failures {
+		return &greetpb.GreetResponse{Result: "Hello " + req.GetGreeting().GetFirstName()}, nil
+	}
+	grpc.SetTrailer(ctx, metadata.Pairs("grpc-retry-pushback-ms", strconv.FormatInt(g.pushback.Milliseconds(), 10)))
+	st, _ := status.New(codes.ResourceExhausted, "Rate limit exceeded").WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(g.pushback)})
+	return nil, st.Err()
+}
+
+func (g *flakyGreeter) recorded() ([]time.Time, []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]time.Time(nil), g.attempts...), append([]string(nil), g.keys...)
+}
+
+// dialGreeter serves g over an in-memory listener and dials it with policy
+func dialGreeter(t *testing.T, g *flakyGreeter, policy Policy) greetpb.GreetServiceClient {
+	t.Helper()
+	s := grpc.NewServer()
+	greetpb.RegisterGreetServiceServer(s, g)
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := Dial("bufnet", policy,
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return greetpb.NewGreetServiceClient(conn)
+}
+
+func TestServiceConfig(t *testing.T) {
+	var sc serviceConfig
+	if err := json.Unmarshal([]byte(BlogPolicy().ServiceConfig()), &sc); err != nil {
+		t.Fatalf("ServiceConfig is not JSON: %v", err)
+	}
+	retried := map[methodName]*retryPolicyConfig{}
+	for _, mc := range sc.MethodConfig {
+		for _, name := range mc.Name {
+			retried[name] = mc.RetryPolicy
+		}
+	}
+	create := retried[methodName{Service: "blog.BlogService", Method: "CreateBlog"}]
+	if create == nil {
+		t.Fatal("CreateBlog has no retry policy")
+	}
+	if create.InitialBackoff != "0.1s" || create.MaxBackoff != "2s" {
+		t.Errorf("CreateBlog backoff = %v to %v, want 0.1s to 2s", create.InitialBackoff, create.MaxBackoff)
+	}
+	if len(create.RetryableStatusCodes) != 2 || create.RetryableStatusCodes[0] != "UNAVAILABLE" || create.RetryableStatusCodes[1] != "RESOURCE_EXHAUSTED" {
+		t.Errorf("CreateBlog retryable codes = %v, want [UNAVAILABLE RESOURCE_EXHAUSTED]", create.RetryableStatusCodes)
+	}
+	if _, ok := retried[methodName{Service: "blog.BlogService", Method: "ReadBlog"}]; ok {
+		t.Error("the hedged ReadBlog has a retry policy")
+	}
+	if _, ok := retried[methodName{Service: "blog.BlogService", Method: "UpdateBlog"}]; ok {
+		t.Error("UpdateBlog has a retry policy")
+	}
+}
+
+func TestRetryFollowsPushback(t *testing.T) {
+	g := &flakyGreeter{failures: 2, pushback: 100 * time.Millisecond}
+	retryGreet := *createRetry
+	retryGreet.InitialBackoff = time.Millisecond
+	retryGreet.MaxBackoff = time.Millisecond
+	c := dialGreeter(t, g, Policy{Methods: map[string]MethodPolicy{
+		"/greet.GreetService/Greet": {Timeout: 5 * time.Second, Retry: &retryGreet},
+	}})
+
+	res, err := c.Greet(context.Background(), &greetpb.GreetRequest{Greeting: &greetpb.Greeting{FirstName: "Deepak"}})
+	if err != nil {
+		t.Fatalf("Greet: %v", err)
+	}
+	if res.GetResult() != "Hello Deepak" {
+		t.Errorf("Greet = %q, want %q", res.GetResult(), "Hello Deepak")
+	}
+	attempts, keys := g.recorded()
+	if len(attempts) != 3 {
+		t.Fatalf("%v attempts, want 3", len(attempts))
+	}
+	for i := 1; i < len(attempts); i++ {
+		if wait := attempts[i].Sub(attempts[i-1]); wait < g.pushback {
+			t.Errorf("attempt %v sent %v after the previous one, want at least the pushback of %v", i+1, wait, g.pushback)
+		}
+	}
+	if len(keys) != 3 || keys[0] == "" || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Errorf("idempotency keys = %v, want the same key for every attempt", keys)
+	}
+}
+
+func TestHedgingFollowsPushback(t *testing.T) {
+	hedging := &HedgingPolicy{
+		MaxAttempts:   3,
+		HedgingDelay:  100 * time.Millisecond,
+		NonFatalCodes: []codes.Code{codes.Unavailable, codes.ResourceExhausted},
+	}
+	greeting := &greetpb.GreetRequest{Greeting: &greetpb.Greeting{FirstName: "Deepak"}}
+
+	t.Run("waits the pushback", func(t *testing.T) {
+		g := &flakyGreeter{failures: 1, pushback: 200 * time.Millisecond}
+		c := dialGreeter(t, g, Policy{Methods: map[string]MethodPolicy{
+			"/greet.GreetService/Greet": {Timeout: 5 * time.Second, Hedging: hedging},
+		}})
+		if _, err := c.Greet(context.Background(), greeting); err != nil {
+			t.Fatalf("Greet: %v", err)
+		}
+		attempts, _ := g.recorded()
+		if len(attempts) != 2 {
+			t.Fatalf("%v attempts, want 2", len(attempts))
+		}
+		if wait := attempts[1].Sub(attempts[0]); wait < g.pushback {
+			t.Errorf("hedged attempt sent %v after the first one, want at least the pushback of %v", wait, g.pushback)
+		}
+	})
+
+	t.Run("stops when the pushback outlasts the deadline", func(t *testing.T) {
+		g := &flakyGreeter{failures: 1, pushback: time.Minute}
+		c := dialGreeter(t, g, Policy{Methods: map[string]MethodPolicy{
+			"/greet.GreetService/Greet": {Timeout: 5 * time.Second, Hedging: hedging},
+		}})
+		start := time.Now()
+		_, err := c.Greet(context.Background(), greeting)
+		if code := status.Code(err); code != codes.ResourceExhausted {
+			t.Errorf("Greet: %v, want %v", err, codes.ResourceExhausted)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Greet returned after %v, want at once", elapsed)
+		}
+		if attempts, _ := g.recorded(); len(attempts) != 1 {
+			t.Errorf("%v attempts, want 1", len(attempts))
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package grpcclient
+
+import (
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// transient are the codes of the failures a later attempt may not meet:
+// the server is unreachable, restarting, overloaded or rate limiting
+var transient = []codes.Code{codes.Unavailable, codes.ResourceExhausted}
+
+// readRetry retries the calls without side effects
+var readRetry = &RetryPolicy{
+	MaxAttempts:       4,
+	InitialBackoff:    100 * time.Millisecond,
+	MaxBackoff:        2 * time.Second,
+	BackoffMultiplier: 2,
+	RetryableCodes:    transient,
+}
+
+// createRetry retries the calls creating a resource, which the server only
+// creates once per idempotency key
+var createRetry = &RetryPolicy{
+	MaxAttempts:       4,
+	InitialBackoff:    100 * time.Millisecond,
+	MaxBackoff:        2 * time.Second,
+	BackoffMultiplier: 2,
+	RetryableCodes:    transient,
+	IdempotencyKey:    true,
+}
+
+// GreetPolicy is the policy of the greet clients
+func GreetPolicy() Policy {
+	return Policy{
+		Default: MethodPolicy{Timeout: 10 * time.Second},
+		Methods: map[string]MethodPolicy{
+			"/greet.GreetService/Greet":        {Timeout: 5 * time.Second, Retry: readRetry},
+			"/greet.GreetService/CalculateSum": {Timeout: 5 * time.Second, Retry: readRetry},
+			"/greet.GreetService/SquareRoot":   {Timeout: 5 * time.Second, Retry: readRetry},
+		},
+	}
+}
+
+// BlogPolicy is the policy of the blog clients. Updates, deletes and
+// reactions are sent once, as the server cannot tell their retries apart.
+func BlogPolicy() Policy {
+	return Policy{
+		Default: MethodPolicy{Timeout: 10 * time.Second},
+		Methods: map[string]MethodPolicy{
+			"/blog.BlogService/CreateBlog": {Timeout: 10 * time.Second, Retry: createRetry},
+			// Reads are hedged when the first attempt is slower than most,
+			// but not once rate limited, as the other attempts would be
+			// rejected all the same
+			"/blog.BlogService/ReadBlog": {Timeout: 5 * time.Second, Hedging: &HedgingPolicy{
+				MaxAttempts:   3,
+				HedgingDelay:  200 * time.Millisecond,
+				NonFatalCodes: []codes.Code{codes.Unavailable},
+			}},
+			"/blog.BlogService/GetFeed":          {Timeout: 10 * time.Second, Retry: readRetry},
+			"/blog.BlogService/GetBlogStats":     {Timeout: 5 * time.Second, Retry: readRetry},
+			"/blog.BlogService/ListRelatedBlogs": {Timeout: 5 * time.Second, Retry: readRetry},
+		},
+	}
+}
@@ -0,0 +1,166 @@
+package grpcclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"greet/logging"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// IdempotencyKeyHeader is the metadata key of the idempotency key of a
+// call, which servers use to apply the attempts of a call once
+const IdempotencyKeyHeader = "x-idempotency-key"
+
+// WithIdempotencyKey returns a copy of ctx sending key as the idempotency
+// key of its calls, instead of one generated for each call
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, IdempotencyKeyHeader, key)
+}
+
+func hasIdempotencyKey(ctx context.Context) bool {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return len(md.Get(IdempotencyKeyHeader)) > 0
+}
+
+// newIdempotencyKey returns a random idempotency key
+func newIdempotencyKey() string {
+	var key [16]byte
+	rand.Read(key[:])
+	return hex.EncodeToString(key[:])
+}
+
+// withRequestID returns ctx carrying a request ID, so that all the attempts
+// of a call share it
+func withRequestID(ctx context.Context) context.Context {
+	if _, ok := logging.RequestIDFromContext(ctx); ok {
+		return ctx
+	}
+	return logging.ContextWithRequestID(ctx, logging.NewRequestID())
+}
+
+func hasCode(list []codes.Code, code codes.Code) bool {
+	for _, c := range list {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryDelay returns the wait the server asked for before retrying, if any
+func retryDelay(err error) (time.Duration, bool) {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// retry sends a call which grpc-go retries along the retry policy of the
+// service config, with the request ID and idempotency key shared by all its
+// attempts
+func retry(ctx context.Context, p *RetryPolicy, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts []grpc.CallOption) error {
+	ctx = withRequestID(ctx)
+	if p.IdempotencyKey && !hasIdempotencyKey(ctx) {
+		ctx = WithIdempotencyKey(ctx, newIdempotencyKey())
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// hedge sends an attempt of a call every delay, or as soon as the previous
+// one fails with a non fatal code, and keeps the reply of the first one to
+// succeed. The other attempts are cancelled. As in gRFC A6, a failure
+// carrying a RetryInfo delay holds the next attempt back for that delay,
+// and stops the hedging when the call would time out first.
+func hedge(ctx context.Context, p *HedgingPolicy, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts []grpc.CallOption) error {
+	msg, ok := reply.(proto.Message)
+	if !ok {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	ctx = withRequestID(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		reply proto.Message
+		err   error
+	}
+	results := make(chan result, p.MaxAttempts)
+	sent, pending := 0, 0
+	send := func() {
+		sent++
+		pending++
+		if sent > 1 {
+			logging.FromContext(ctx).Debug("Hedging call", logging.F("method", method), logging.F("attempt", sent))
+		}
+		attemptReply := msg.ProtoReflect().New().Interface()
+		go func() {
+			err := invoker(ctx, method, req, attemptReply, cc, opts...)
+			results <- result{attemptReply, err}
+		}()
+	}
+
+	timer := time.NewTimer(p.HedgingDelay)
+	defer timer.Stop()
+	// delayNext schedules the next attempt in d instead of when planned
+	delayNext := func(d time.Duration) {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(d)
+	}
+	maxAttempts := p.MaxAttempts
+	send()
+	var err error
+	for pending > 0 || sent < maxAttempts {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				proto.Reset(msg)
+				proto.Merge(msg, r.reply)
+				return nil
+			}
+			err = r.err
+			if !hasCode(p.NonFatalCodes, status.Code(r.err)) {
+				return err
+			}
+			if sent >= maxAttempts {
+				continue
+			}
+			// The server knows better when it can serve the call again
+			if pushback, ok := retryDelay(r.err); ok {
+				if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < pushback {
+					maxAttempts = sent
+					continue
+				}
+				delayNext(pushback)
+				continue
+			}
+			send()
+		case <-timer.C:
+			if sent < maxAttempts {
+				send()
+				timer.Reset(p.HedgingDelay)
+			}
+		case <-ctx.Done():
+			if err == nil {
+				err = status.FromContextError(ctx.Err()).Err()
+			}
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,371 @@
+// Package grpcserver builds the gRPC servers hosting the greet and blog
+// services, so that the standalone servers and the combined one share the
+// same listener, TLS, authentication, RBAC, health and reflection setup.
+package grpcserver
+
+import (
+	"context"
+	"fmt"
+	"greet/auth"
+	"greet/chaos"
+	"greet/config"
+	"greet/filewatch"
+	"greet/loadshed"
+	"greet/logging"
+	"greet/metrics"
+	"greet/ratelimit"
+	"greet/rbac"
+	"greet/tlsutil"
+	"greet/tracing"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// healthMethods prefixes the methods of the health service, answered
+// without credentials so that probes need none
+const healthMethods = "/grpc.health.v1.Health/"
+
+// Server is a gRPC server along with its listener, its health service and
+// the watchers reloading its certificates, API keys and RBAC policy
+type Server struct {
+	GRPC     *grpc.Server
+	Health   *health.Server
+	lis      net.Listener
+	watchers []*filewatch.Watcher
+	metrics  *http.Server
+	tracer   *sdktrace.TracerProvider
+	chaos    *chaos.Injector
+
+	mu       sync.Mutex
+	probed   map[string]bool
+	done     chan struct{}
+	released sync.Once
+}
+
+// New listens on cfg.Addr and creates the gRPC server. Services are
+// registered on GRPC before calling Serve.
+func New(cfg config.Server) (*Server, error) {
+	s := &Server{
+		Health: health.NewServer(),
+		probed: map[string]bool{},
+		done:   make(chan struct{}),
+	}
+	tracer, err := tracing.Setup(cfg.Tracing.Exporter, cfg.Tracing.Endpoint, tracing.Options{
+		ServiceName: cfg.Tracing.ServiceName,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.tracer = tracer
+	opts, err := s.options(cfg)
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	lis, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	s.lis = lis
+	s.GRPC = grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(s.GRPC, s.Health)
+	if s.chaos != nil {
+		s.chaos.Register(s.GRPC)
+	}
+
+	// Register reflection service on gRPC server for evans CLI
+	reflection.Register(s.GRPC)
+
+	if cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		s.metrics = &http.Server{Addr: cfg.MetricsAddr, Handler: mux}
+	}
+	return s, nil
+}
+
+func (s *Server) options(cfg config.Server) ([]grpc.ServerOption, error) {
+	// Metrics, traces and access logs come first to also record the calls
+	// the other interceptors reject, then panics are recovered so that
+	// they are recorded as Internal errors
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			metrics.UnaryServerInterceptor(),
+			otelgrpc.UnaryServerInterceptor(),
+			logging.UnaryServerInterceptor(tracing.LogFields),
+			UnaryRecoveryInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			metrics.StreamServerInterceptor(),
+			otelgrpc.StreamServerInterceptor(),
+			logging.StreamServerInterceptor(tracing.LogFields),
+			StreamRecoveryInterceptor(),
+		),
+	}
+
+	// Load is shed before spending any time on the calls to reject
+	if cfg.LoadShedding.Enabled {
+		limiter := loadshed.New(loadshed.Options{
+			InitialLimit: cfg.LoadShedding.InitialLimit,
+			MinLimit:     cfg.LoadShedding.MinLimit,
+			MaxLimit:     cfg.LoadShedding.MaxLimit,
+			Tolerance:    cfg.LoadShedding.Tolerance,
+		})
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(limiter.UnaryServerInterceptor()),
+			grpc.ChainStreamInterceptor(limiter.StreamServerInterceptor()),
+		)
+	}
+
+	// With SSL
+	if cfg.TLS.Enabled() {
+		certs, err := tlsutil.NewReloader(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("loading certificates: %v", err)
+		}
+		// Certificates rotated on disk are picked up without restarting
+		s.watchers = append(s.watchers, certs.Watch(10*time.Second))
+		opts = append(opts, grpc.Creds(credentials.NewTLS(certs.ServerConfig())))
+	}
+
+	// Callers authenticate with an API key, a bearer token or, with mutual
+	// TLS, their client certificate. When none is configured, identities
+	// may be taken from the x-user-id and x-user-roles metadata.
+	var authenticators []auth.Authenticator
+	if cfg.Auth.APIKeys != "" {
+		store, err := auth.OpenAPIKeyStore(cfg.Auth.APIKeys)
+		if err != nil {
+			return nil, fmt.Errorf("loading API keys: %v", err)
+		}
+		s.watchers = append(s.watchers, store.Watch(5*time.Second))
+		authenticators = append(authenticators, store)
+	}
+	if cfg.Auth.JWKS != "" {
+		keys, err := auth.LoadJWKS(cfg.Auth.JWKS)
+		if err != nil {
+			return nil, fmt.Errorf("loading JWKS: %v", err)
+		}
+		authenticators = append(authenticators, &auth.JWT{Keys: keys, Audience: cfg.Auth.JWTAudience, Leeway: 30 * time.Second})
+	}
+	if cfg.TLS.ClientCA != "" {
+		authenticators = append(authenticators, auth.PeerCertificate{})
+	}
+	if len(authenticators) == 0 && cfg.Auth.TrustIdentityHeaders {
+		authenticators = append(authenticators, auth.Header{})
+	}
+	if len(authenticators) > 0 {
+		authenticator := auth.Chain(authenticators...)
+		if cfg.Auth.Required {
+			optional, required := authenticator, auth.Required(authenticator)
+			authenticator = auth.AuthenticatorFunc(func(ctx context.Context, fullMethod string) (*auth.Principal, error) {
+				if strings.HasPrefix(fullMethod, healthMethods) {
+					return optional.Authenticate(ctx, fullMethod)
+				}
+				return required.Authenticate(ctx, fullMethod)
+			})
+		}
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(authenticator)),
+			grpc.ChainStreamInterceptor(auth.StreamServerInterceptor(authenticator)),
+		)
+	}
+
+	// Rate limits, keyed by the caller once authenticated
+	limiter, err := rateLimiter(cfg.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(limiter.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(limiter.StreamServerInterceptor()),
+	)
+
+	// Role based access control, checked once the caller is authenticated
+	if cfg.RBAC.Policy != "" {
+		enforcer, err := rbac.NewEnforcer(cfg.RBAC.Policy, cfg.RBAC.AuditOnly)
+		if err != nil {
+			return nil, fmt.Errorf("loading RBAC policy: %v", err)
+		}
+		s.watchers = append(s.watchers, enforcer.Watch(5*time.Second))
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(enforcer.UnaryServerInterceptor()),
+			grpc.ChainStreamInterceptor(enforcer.StreamServerInterceptor()),
+		)
+	}
+	// Faults are injected last, in the calls the handlers would answer
+	if cfg.Chaos.Enabled {
+		logging.Warn("Fault injection enabled, faults set with the chaos.ChaosService RPC affect the calls")
+		s.chaos = chaos.NewInjector()
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(s.chaos.UnaryServerInterceptor()),
+			grpc.ChainStreamInterceptor(s.chaos.StreamServerInterceptor()),
+		)
+	}
+	return opts, nil
+}
+
+// rateLimiter builds the limiter of the rate_limit settings
+func rateLimiter(cfg config.RateLimit) (*ratelimit.Limiter, error) {
+	var rules []ratelimit.Rule
+	for pattern, limit := range cfg.Methods {
+		rules = append(rules, ratelimit.Rule{
+			Pattern: pattern,
+			Limit:   ratelimit.Limit{Rate: limit.Rate, Burst: limit.Burst, MaxStreams: limit.MaxStreams},
+		})
+	}
+	return ratelimit.New(ratelimit.Limit{Rate: cfg.Rate, Burst: cfg.Burst, MaxStreams: cfg.MaxStreams}, rules)
+}
+
+// Addr is the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.lis.Addr()
+}
+
+// Probe reports service as SERVING in the health service while check
+// succeeds. check runs now, then every interval until the server stops.
+func (s *Server) Probe(service string, interval time.Duration, check func(context.Context) error) {
+	s.mu.Lock()
+	s.probed[service] = true
+	s.mu.Unlock()
+
+	var last error
+	run := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := check(ctx)
+		cancel()
+		if err != nil {
+			if last == nil {
+				logging.Warn("Health check failed", logging.F("service", service), logging.Err(err))
+			}
+			s.Health.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+		} else {
+			if last != nil {
+				logging.Info("Health check recovered", logging.F("service", service))
+			}
+			s.Health.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+		}
+		last = err
+	}
+	run()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				run()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// Serve reports the server and every registered service without a probe
+// as SERVING, starts serving the metrics, then accepts connections until
+// the server is stopped
+func (s *Server) Serve() error {
+	if s.metrics != nil {
+		metrics.Register(s.GRPC)
+		go func() {
+			logging.Info("Serving metrics", logging.F("addr", s.metrics.Addr), logging.F("path", "/metrics"))
+			if err := s.metrics.ListenAndServe(); err != http.ErrServerClosed {
+				logging.Error("Failed to serve metrics", logging.Err(err))
+			}
+		}()
+	}
+	s.mu.Lock()
+	for service := range s.GRPC.GetServiceInfo() {
+		if !s.probed[service] {
+			s.Health.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+		}
+	}
+	s.mu.Unlock()
+	s.Health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	return s.GRPC.Serve(s.lis)
+}
+
+// Shutdown reports every service as NOT_SERVING, stops accepting
+// connections and waits up to timeout for the calls in flight before
+// closing the remaining connections. The probes, the watchers, the metrics
+// endpoint and the tracer are stopped once the server is.
+func (s *Server) Shutdown(timeout time.Duration) {
+	s.Health.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		s.GRPC.GracefulStop()
+		close(stopped)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+	case <-timer.C:
+		logging.Warn("Calls still running, forcing the server to stop", logging.F("timeout", timeout))
+		s.GRPC.Stop()
+		<-stopped
+	}
+	s.release()
+}
+
+// Stop reports every service as NOT_SERVING and closes the listener and
+// every connection at once, then stops the probes, the watchers, the
+// metrics endpoint and the tracer
+func (s *Server) Stop() {
+	s.Health.Shutdown()
+	s.GRPC.Stop()
+	s.release()
+}
+
+// release stops the probes, the watchers and the metrics endpoint, then
+// exports the spans still queued. Only the first call does anything, so
+// that Stop and Shutdown may both run.
+func (s *Server) release() {
+	s.released.Do(s.releaseOnce)
+}
+
+func (s *Server) releaseOnce() {
+	if s.metrics != nil {
+		s.metrics.Close()
+	}
+	close(s.done)
+	s.closeWatchers()
+	if s.tracer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.tracer.Shutdown(ctx); err != nil {
+			logging.Error("Failed to export the last spans", logging.Err(err))
+		}
+	}
+}
+
+// WaitForSignal blocks until the process receives SIGINT or SIGTERM
+func WaitForSignal() os.Signal {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(ch)
+	return <-ch
+}
+
+func (s *Server) closeWatchers() {
+	for _, w := range s.watchers {
+		w.Close()
+	}
+	s.watchers = nil
+}
@@ -0,0 +1,18 @@
+package grpcserver
+
+import (
+	"greet/config"
+	"testing"
+	"time"
+)
+
+func TestStopAndShutdownTogether(t *testing.T) {
+	s, err := New(config.DefaultServer("127.0.0.1:0"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	go s.Serve()
+	s.Stop()
+	s.Shutdown(time.Second)
+	s.Stop()
+}
@@ -0,0 +1,57 @@
+package grpcserver
+
+import (
+	"context"
+	"fmt"
+	"greet/logging"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var panicsRecovered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grpc_server_panics_recovered_total",
+	Help: "Total number of panics recovered while handling RPCs.",
+}, []string{"grpc_method"})
+
+// recovered logs the panic of a call with its stack and returns the
+// Internal error answered in its place, without the panic value which may
+// reveal the internals of the server
+func recovered(ctx context.Context, fullMethod string, r interface{}) error {
+	panicsRecovered.WithLabelValues(fullMethod).Inc()
+	logging.FromContext(ctx).Error("Recovered from a panic",
+		logging.F("panic", fmt.Sprint(r)),
+		logging.F("stack", string(debug.Stack())),
+	)
+	return status.Errorf(codes.Internal, "Internal error")
+}
+
+// UnaryRecoveryInterceptor turns the panics of unary handlers into
+// Internal errors, keeping the server up
+func UnaryRecoveryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				res, err = nil, recovered(ctx, info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecoveryInterceptor turns the panics of streaming handlers into
+// Internal errors, keeping the server up
+func StreamRecoveryInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(ss.Context(), info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,80 @@
+package grpcserver
+
+import (
+	"context"
+	"greet/greet/greetpb"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// panickingGreeter panics in Greet and LongGreet and answers GreetWithDeadline
+type panickingGreeter struct {
+	greetpb.UnimplementedGreetServiceServer
+}
+
+func (*panickingGreeter) Greet(context.Context, *greetpb.GreetRequest) (*greetpb.GreetResponse, error) {
+	panic("unary handler bug")
+}
+
+func (*panickingGreeter) LongGreet(greetpb.GreetService_LongGreetServer) error {
+	panic("stream handler bug")
+}
+
+func (*panickingGreeter) GreetWithDeadline(_ context.Context, req *greetpb.GreetWithDeadlineRequest) (*greetpb.GreetWithDeadlineResponse, error) {
+	return &greetpb.GreetWithDeadlineResponse{Result: "Hello " + req.GetGreeting().GetFirstName()}, nil
+}
+
+func TestRecoveryKeepsServing(t *testing.T) {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryRecoveryInterceptor()),
+		grpc.StreamInterceptor(StreamRecoveryInterceptor()),
+	)
+	greetpb.RegisterGreetServiceServer(s, &panickingGreeter{})
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	c := greetpb.NewGreetServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	greeting := &greetpb.Greeting{FirstName: "Deepak"}
+
+	_, err = c.Greet(ctx, &greetpb.GreetRequest{Greeting: greeting})
+	if code := status.Code(err); code != codes.Internal {
+		t.Errorf("panicking unary call: %v, want %v", err, codes.Internal)
+	}
+	if st, _ := status.FromError(err); st.Message() != "Internal error" {
+		t.Errorf("panicking unary call answered %q, want the panic kept out of the message", st.Message())
+	}
+
+	stream, err := c.LongGreet(ctx)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	_, err = stream.CloseAndRecv()
+	if code := status.Code(err); code != codes.Internal {
+		t.Errorf("panicking stream: %v, want %v", err, codes.Internal)
+	}
+
+	res, err := c.GreetWithDeadline(ctx, &greetpb.GreetWithDeadlineRequest{Greeting: greeting})
+	if err != nil {
+		t.Fatalf("call after the panics: %v", err)
+	}
+	if res.GetResult() != "Hello Deepak" {
+		t.Errorf("call after the panics = %q, want %q", res.GetResult(), "Hello Deepak")
+	}
+}
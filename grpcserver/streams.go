@@ -0,0 +1,22 @@
+package grpcserver
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StreamError reports a failed Recv or Send of a streaming handler with the
+// status of the stream, such as Canceled when the client went away, and
+// other failures as Internal, instead of taking the whole server down
+func StreamError(err error, msg string) error {
+	switch err {
+	case context.Canceled, context.DeadlineExceeded:
+		return status.Errorf(status.FromContextError(err).Code(), "%v : %v", msg, err)
+	}
+	if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+		return status.Errorf(st.Code(), "%v : %v", msg, st.Message())
+	}
+	return status.Errorf(codes.Internal, "%v : %v", msg, err)
+}
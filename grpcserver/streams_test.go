@@ -0,0 +1,33 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStreamError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantCode    codes.Code
+		wantMessage string
+	}{
+		{"client went away", context.Canceled, codes.Canceled, "Cannot read : context canceled"},
+		{"deadline", context.DeadlineExceeded, codes.DeadlineExceeded, "Cannot read : context deadline exceeded"},
+		{"stream status", status.Error(codes.ResourceExhausted, "message too large"), codes.ResourceExhausted, "Cannot read : message too large"},
+		{"unknown status", status.Error(codes.Unknown, "broken"), codes.Internal, "Cannot read : rpc error: code = Unknown desc = broken"},
+		{"other error", errors.New("broken pipe"), codes.Internal, "Cannot read : broken pipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(StreamError(tt.err, "Cannot read"))
+			if st.Code() != tt.wantCode || st.Message() != tt.wantMessage {
+				t.Errorf("StreamError = %v %q, want %v %q", st.Code(), st.Message(), tt.wantCode, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package loadshed
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// PriorityHeader is the metadata key of the priority of a call: low,
+// normal, high or critical
+const PriorityHeader = "x-priority"
+
+// healthMethods prefixes the methods of the health service, critical so
+// that an overloaded server is not mistaken for a dead one
+const healthMethods = "/grpc.health.v1.Health/"
+
+var (
+	shed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_load_shed_total",
+		Help: "Total number of RPCs rejected because the server was overloaded.",
+	}, []string{"grpc_method", "priority"})
+	concurrencyLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "grpc_server_concurrency_limit",
+		Help: "Number of unary RPCs the server currently handles at once before shedding load.",
+	})
+)
+
+// CallPriority returns the priority of a call, from its metadata. Callers
+// get at most high priority, critical is kept for health checks so that
+// no caller can bypass shedding.
+func CallPriority(ctx context.Context, fullMethod string) Priority {
+	if strings.HasPrefix(fullMethod, healthMethods) {
+		return PriorityCritical
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(PriorityHeader); len(values) > 0 {
+			if p := ParsePriority(values[0]); p < PriorityHigh {
+				return p
+			}
+			return PriorityHigh
+		}
+	}
+	return PriorityNormal
+}
+
+func overloaded(fullMethod string, p Priority) error {
+	shed.WithLabelValues(fullMethod, p.String()).Inc()
+	return status.Errorf(codes.Unavailable, "Server overloaded, %v rejected", fullMethod)
+}
+
+// UnaryServerInterceptor sheds the unary calls beyond the limit, and adapts
+// it to the latency of the others
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		p := CallPriority(ctx, info.FullMethod)
+		ok, done := l.Acquire(p)
+		if !ok {
+			return nil, overloaded(info.FullMethod, p)
+		}
+		// Deferred, so that a panicking handler releases its slot too
+		var err error
+		defer func() {
+			done(status.Code(err) == codes.DeadlineExceeded)
+			concurrencyLimit.Set(float64(l.Limit()))
+		}()
+		var res interface{}
+		res, err = handler(ctx, req)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor sheds the streaming calls started while the unary
+// calls are at their limit. Streams last as long as their clients want,
+// their number is bounded by the rate limiter instead.
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		p := CallPriority(ss.Context(), info.FullMethod)
+		if !l.Check(p) {
+			return overloaded(info.FullMethod, p)
+		}
+		return handler(srv, ss)
+	}
+}
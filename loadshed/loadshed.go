@@ -0,0 +1,211 @@
+// Package loadshed sheds the calls a server cannot handle in time, before
+// they queue up.
+//
+// A Limiter bounds the unary calls handled at once with a limit adapting to
+// the latency of the handlers, in the AIMD fashion of TCP congestion
+// control: while calls are about as fast as without load the limit grows by
+// one every limit calls, and as soon as they slow down under load it shrinks
+// by a tenth. Calls beyond the limit are rejected at once with Unavailable,
+// which clients may retry elsewhere or later.
+//
+// Callers mark their calls with the x-priority metadata. The less important
+// a call, the earlier it is shed: low priority calls only get half of the
+// limit, normal ones nine tenths, high ones all of it, and critical ones,
+// which only health checks are, are never shed.
+package loadshed
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Priority ranks the calls to shed first under pressure
+type Priority int
+
+// Priorities, from the first shed
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	PriorityCritical
+)
+
+var priorityNames = []string{"low", "normal", "high", "critical"}
+
+func (p Priority) String() string {
+	if p < PriorityLow || p > PriorityCritical {
+		return "unknown"
+	}
+	return priorityNames[p]
+}
+
+// ParsePriority returns the priority named s, normal when it is unknown
+func ParsePriority(s string) Priority {
+	for i, name := range priorityNames {
+		if strings.EqualFold(s, name) {
+			return Priority(i)
+		}
+	}
+	return PriorityNormal
+}
+
+// share is the fraction of the limit the calls of a priority may use
+var share = map[Priority]float64{
+	PriorityLow:    0.5,
+	PriorityNormal: 0.9,
+	PriorityHigh:   1,
+}
+
+// Options configures a Limiter
+type Options struct {
+	// InitialLimit is the limit before any call completes, 100 when zero
+	InitialLimit int
+	// MinLimit and MaxLimit bound the limit, 10 and 1000 when zero
+	MinLimit int
+	MaxLimit int
+	// Tolerance is how many times slower than without load calls may get
+	// before the limit shrinks, 2 when zero
+	Tolerance float64
+	// Window is how long the latency without load is measured over before
+	// being measured again, following the handlers when they get slower
+	// for good. 30s when zero.
+	Window time.Duration
+}
+
+// slack is the latency increase never taken for pressure, as the fastest
+// handlers easily get several times slower from scheduling alone
+const slack = 5 * time.Millisecond
+
+// decreaseEvery spaces the decreases of the limit, so that the calls slowed
+// down by the same burst shrink it once
+const decreaseEvery = 100 * time.Millisecond
+
+// Limiter adapts the number of calls handled at once to the latency of the
+// handlers
+type Limiter struct {
+	opts Options
+	// Now returns the current time, defaults to time.Now
+	Now func() time.Time
+
+	mu           sync.Mutex
+	limit        float64
+	inflight     int
+	windowStart  time.Time
+	minRTT       time.Duration // over the current window
+	prevMinRTT   time.Duration // over the previous window
+	lastDecrease time.Time
+}
+
+// New returns a limiter
+func New(opts Options) *Limiter {
+	if opts.MinLimit <= 0 {
+		opts.MinLimit = 10
+	}
+	if opts.MaxLimit <= 0 {
+		opts.MaxLimit = 1000
+	}
+	if opts.MaxLimit < opts.MinLimit {
+		opts.MaxLimit = opts.MinLimit
+	}
+	if opts.InitialLimit <= 0 {
+		opts.InitialLimit = 100
+	}
+	if opts.Tolerance <= 1 {
+		opts.Tolerance = 2
+	}
+	if opts.Window <= 0 {
+		opts.Window = 30 * time.Second
+	}
+	initial := math.Max(float64(opts.MinLimit), math.Min(float64(opts.MaxLimit), float64(opts.InitialLimit)))
+	return &Limiter{opts: opts, limit: initial}
+}
+
+func (l *Limiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// Limit returns the current limit
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// admits reports whether a call of priority p fits with inflight calls in
+// flight
+func (l *Limiter) admits(p Priority, inflight int) bool {
+	if p >= PriorityCritical {
+		return true
+	}
+	return float64(inflight) < l.limit*share[p]
+}
+
+// Check reports whether a call of priority p would be admitted now,
+// without counting it, for the calls whose latency says nothing of the
+// load such as streams
+func (l *Limiter) Check(p Priority) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.admits(p, l.inflight)
+}
+
+// Acquire admits a call of priority p. It returns false when the call is
+// to be shed, and otherwise a function to call once the call is handled,
+// telling whether it failed for lack of time.
+func (l *Limiter) Acquire(p Priority) (bool, func(timedOut bool)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.admits(p, l.inflight) {
+		return false, nil
+	}
+	l.inflight++
+	inflight := l.inflight
+	start := l.now()
+	var once sync.Once
+	return true, func(timedOut bool) {
+		once.Do(func() {
+			l.release(start, inflight, timedOut)
+		})
+	}
+}
+
+// release records the latency of a call started with inflight calls in
+// flight, and adapts the limit
+func (l *Limiter) release(start time.Time, inflight int, timedOut bool) {
+	now := l.now()
+	rtt := now.Sub(start)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+
+	if now.Sub(l.windowStart) > l.opts.Window {
+		l.windowStart = now
+		l.prevMinRTT, l.minRTT = l.minRTT, 0
+	}
+	if !timedOut && (l.minRTT == 0 || rtt < l.minRTT) {
+		l.minRTT = rtt
+	}
+	baseline := l.minRTT
+	if l.prevMinRTT != 0 && (baseline == 0 || l.prevMinRTT < baseline) {
+		baseline = l.prevMinRTT
+	}
+
+	// Slow calls only tell of pressure when there are many of them
+	loaded := float64(inflight)*2 >= l.limit
+	slow := timedOut || (baseline > 0 && float64(rtt) > l.opts.Tolerance*float64(baseline) && rtt > baseline+slack)
+	switch {
+	case slow && loaded:
+		if now.Sub(l.lastDecrease) >= decreaseEvery {
+			l.lastDecrease = now
+			l.limit = math.Max(float64(l.opts.MinLimit), l.limit*0.9)
+		}
+	case !slow && loaded:
+		l.limit = math.Min(float64(l.opts.MaxLimit), l.limit+1/l.limit)
+	}
+}
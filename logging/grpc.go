@@ -0,0 +1,191 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader is the metadata key of the request ID, sent by clients
+// and echoed by servers in the response headers
+const RequestIDHeader = "x-request-id"
+
+// maxRequestIDLength bounds the request IDs accepted from callers
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random request ID
+func NewRequestID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID id,
+// sent by the client interceptors
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// validRequestID accepts the printable ASCII IDs of a reasonable length,
+// which cannot forge log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// incomingRequestID returns the request ID sent by the caller, or a new one
+func incomingRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RequestIDHeader); len(values) == 1 && validRequestID(values[0]) {
+			return values[0]
+		}
+	}
+	return NewRequestID()
+}
+
+// ContextFields returns fields describing a call from its context, such as
+// the ID of its trace, added to the records of the call
+type ContextFields func(ctx context.Context) []Field
+
+// startCall returns the context of a call carrying its request ID and a
+// logger recording it along with the method and the extra fields
+func startCall(ctx context.Context, fullMethod string, extra []ContextFields) context.Context {
+	id := incomingRequestID(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+
+	fields := []Field{F("request_id", id), F("method", fullMethod)}
+	for _, fn := range extra {
+		fields = append(fields, fn(ctx)...)
+	}
+	ctx = ContextWithRequestID(ctx, id)
+	return WithContext(ctx, Default().With(fields...))
+}
+
+// healthMethods prefixes the methods of the health service, whose calls
+// are logged at the debug level as probes make a lot of them
+const healthMethods = "/grpc.health.v1.Health/"
+
+// CodeLevel returns the level of the access log of a call ending with code:
+// errors of the server are errors, the ones worth a look are warnings
+func CodeLevel(code codes.Code) Level {
+	switch code {
+	case codes.OK, codes.Canceled, codes.InvalidArgument, codes.NotFound,
+		codes.AlreadyExists, codes.Unauthenticated:
+		return LevelInfo
+	case codes.DeadlineExceeded, codes.PermissionDenied, codes.ResourceExhausted,
+		codes.FailedPrecondition, codes.Aborted, codes.OutOfRange, codes.Unavailable:
+		return LevelWarn
+	}
+	return LevelError
+}
+
+// access writes the access log of a call
+func access(ctx context.Context, fullMethod string, start time.Time, err error) {
+	code := status.Code(err)
+	level := CodeLevel(code)
+	if code == codes.OK && strings.HasPrefix(fullMethod, healthMethods) {
+		level = LevelDebug
+	}
+	l := FromContext(ctx)
+	if !l.Enabled(level) {
+		return
+	}
+	fields := []Field{
+		F("code", code.String()),
+		F("duration_ms", float64(time.Since(start))/float64(time.Millisecond)),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields = append(fields, F("peer", p.Addr.String()))
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ua := md.Get("user-agent"); len(ua) > 0 {
+			fields = append(fields, F("user_agent", ua[0]))
+		}
+	}
+	if err != nil {
+		fields = append(fields, F("error", status.Convert(err).Message()))
+	}
+	l.Log(level, "Call finished", fields...)
+}
+
+// UnaryServerInterceptor gives each unary call a request ID and a logger
+// adding the extra fields, and writes its access log
+func UnaryServerInterceptor(extra ...ContextFields) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		ctx = startCall(ctx, info.FullMethod, extra)
+		res, err := handler(ctx, req)
+		access(ctx, info.FullMethod, start, err)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor gives each streaming call a request ID and a
+// logger adding the extra fields, and writes its access log
+func StreamServerInterceptor(extra ...ContextFields) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx := startCall(ss.Context(), info.FullMethod, extra)
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		access(ctx, info.FullMethod, start, err)
+		return err
+	}
+}
+
+// serverStream carries the logger of the call in its context
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// outgoingRequestID sends the request ID carried by ctx, or a new one
+func outgoingRequestID(ctx context.Context) context.Context {
+	id, ok := RequestIDFromContext(ctx)
+	if !ok {
+		id = NewRequestID()
+	}
+	return metadata.AppendToOutgoingContext(ctx, RequestIDHeader, id)
+}
+
+// UnaryClientInterceptor sends the request ID of the context with each
+// unary call, a new one when it carries none
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingRequestID(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor sends the request ID of the context with each
+// streaming call, a new one when it carries none
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingRequestID(ctx), desc, cc, method, opts...)
+	}
+}
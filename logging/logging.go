@@ -0,0 +1,379 @@
+// Package logging writes leveled, structured log records as JSON or
+// logfmt lines.
+//
+// Records carry a message and key value fields. Fields whose key names a
+// secret, such as password, token or authorization, are written as
+// [REDACTED] whatever the logger they go through.
+//
+// Loggers travel with the context of a call: the server interceptors attach
+// one carrying the request ID and trace ID of the call, which FromContext
+// returns to the handlers.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Level is the severity of a record
+type Level int
+
+// Levels, from the most verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named s: debug, info, warn or error
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	if strings.EqualFold(s, "warning") {
+		return LevelWarn, nil
+	}
+	return LevelInfo, fmt.Errorf("logging: unknown level %q", s)
+}
+
+// Formats of the records
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Field is a key value pair of a record
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns the field key=value
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err returns the error field of a record
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// Redacted replaces the value of the fields naming a secret
+const Redacted = "[REDACTED]"
+
+// DefaultRedact lists the keys always redacted
+var DefaultRedact = []string{
+	"password", "secret", "token", "access_token", "refresh_token",
+	"authorization", "api_key", "x_api_key", "cookie", "private_key",
+}
+
+// Options configures a Logger
+type Options struct {
+	// Level is the lowest level written
+	Level Level
+	// Format is FormatJSON, the default, or FormatLogfmt
+	Format string
+	// Redact lists the keys redacted on top of DefaultRedact. Keys match
+	// regardless of case, dashes and underscores.
+	Redact []string
+}
+
+// Logger writes records to an output. A Logger is safe for concurrent use,
+// the loggers derived from it with With share its output.
+type Logger struct {
+	out    *output
+	fields []Field
+}
+
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  Level
+	logfmt bool
+	redact map[string]bool
+	now    func() time.Time
+}
+
+// New returns a logger writing to w
+func New(w io.Writer, opts Options) (*Logger, error) {
+	out := &output{w: w, level: opts.Level, redact: map[string]bool{}, now: time.Now}
+	switch opts.Format {
+	case "", FormatJSON:
+	case FormatLogfmt:
+		out.logfmt = true
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q", opts.Format)
+	}
+	for _, key := range append(DefaultRedact, opts.Redact...) {
+		out.redact[normalizeKey(key)] = true
+	}
+	return &Logger{out: out}, nil
+}
+
+func normalizeKey(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "-", "_")
+}
+
+// With returns a logger adding fields to every record
+func (l *Logger) With(fields ...Field) *Logger {
+	all := make([]Field, 0, len(l.fields)+len(fields))
+	all = append(all, l.fields...)
+	all = append(all, fields...)
+	return &Logger{out: l.out, fields: all}
+}
+
+// Enabled reports whether records of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.out.level
+}
+
+// Log writes a record of the level
+func (l *Logger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	all := make([]Field, 0, 3+len(l.fields)+len(fields))
+	all = append(all,
+		F("time", l.out.now().UTC().Format(time.RFC3339Nano)),
+		F("level", level.String()),
+		F("msg", msg),
+	)
+	all = append(all, l.fields...)
+	all = append(all, fields...)
+
+	var buf bytes.Buffer
+	if l.out.logfmt {
+		l.out.writeLogfmt(&buf, all)
+	} else {
+		l.out.writeJSON(&buf, all)
+	}
+	buf.WriteByte('\n')
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+// Debug writes a debug record
+func (l *Logger) Debug(msg string, fields ...Field) { l.Log(LevelDebug, msg, fields...) }
+
+// Info writes an info record
+func (l *Logger) Info(msg string, fields ...Field) { l.Log(LevelInfo, msg, fields...) }
+
+// Warn writes a warning record
+func (l *Logger) Warn(msg string, fields ...Field) { l.Log(LevelWarn, msg, fields...) }
+
+// Error writes an error record
+func (l *Logger) Error(msg string, fields ...Field) { l.Log(LevelError, msg, fields...) }
+
+// Fatal writes an error record and exits the process with status 1
+func (l *Logger) Fatal(msg string, fields ...Field) {
+	l.Log(LevelError, msg, fields...)
+	os.Exit(1)
+}
+
+// value returns the value written for a field, after redaction
+func (o *output) value(f Field) interface{} {
+	if o.redact[normalizeKey(f.Key)] {
+		return Redacted
+	}
+	switch v := f.Value.(type) {
+	case nil:
+		return nil
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	case map[string]string:
+		return o.redactMap(v)
+	}
+	return f.Value
+}
+
+// redactMap redacts the entries of maps such as metadata
+func (o *output) redactMap(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if o.redact[normalizeKey(k)] {
+			v = Redacted
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func (o *output) writeJSON(buf *bytes.Buffer, fields []Field) {
+	buf.WriteByte('{')
+	seen := map[string]bool{}
+	first := true
+	for _, f := range fields {
+		if seen[f.Key] {
+			continue
+		}
+		seen[f.Key] = true
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		key, _ := json.Marshal(f.Key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(o.value(f))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(f.Value))
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+}
+
+func (o *output) writeLogfmt(buf *bytes.Buffer, fields []Field) {
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(logfmtKey(f.Key))
+		buf.WriteByte('=')
+		switch v := o.value(f).(type) {
+		case nil:
+		case string:
+			buf.WriteString(logfmtValue(v))
+		case map[string]string:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			pairs := make([]string, len(keys))
+			for j, k := range keys {
+				pairs[j] = k + ":" + v[k]
+			}
+			buf.WriteString(logfmtValue(strings.Join(pairs, ",")))
+		default:
+			buf.WriteString(logfmtValue(fmt.Sprint(v)))
+		}
+	}
+}
+
+// logfmtKey replaces the characters not allowed in a key
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == unicode.ReplacementChar {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// logfmtValue quotes values holding spaces, quotes, equal signs or control
+// characters
+func logfmtValue(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == unicode.ReplacementChar || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+var (
+	defaultMu sync.RWMutex
+	std       = mustNew(os.Stderr, Options{Level: LevelInfo})
+)
+
+func mustNew(w io.Writer, opts Options) *Logger {
+	l, err := New(w, opts)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// Default returns the process wide logger, writing JSON info records to
+// stderr until SetDefault replaces it
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return std
+}
+
+// SetDefault replaces the process wide logger
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	std = l
+}
+
+// Setup installs a logger writing to stderr records at least as severe as
+// level, in format, redacting the redact keys on top of the default ones
+func Setup(level, format string, redact []string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	l, err := New(os.Stderr, Options{Level: lvl, Format: format, Redact: redact})
+	if err != nil {
+		return err
+	}
+	SetDefault(l)
+	return nil
+}
+
+// Debug writes a debug record with the default logger
+func Debug(msg string, fields ...Field) { Default().Debug(msg, fields...) }
+
+// Info writes an info record with the default logger
+func Info(msg string, fields ...Field) { Default().Info(msg, fields...) }
+
+// Warn writes a warning record with the default logger
+func Warn(msg string, fields ...Field) { Default().Warn(msg, fields...) }
+
+// Error writes an error record with the default logger
+func Error(msg string, fields ...Field) { Default().Error(msg, fields...) }
+
+// Fatal writes an error record with the default logger and exits the
+// process with status 1
+func Fatal(msg string, fields ...Field) { Default().Fatal(msg, fields...) }
+
+type loggerKey struct{}
+
+// WithContext returns a copy of ctx carrying l
+func WithContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+)
+
+// The started, handled and message counters and the latency histograms of
+// the RPCs are recorded by go-grpc-prometheus, the calls in flight here
+// with the same labels
+var serverInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "grpc_server_in_flight",
+	Help: "Number of RPCs being handled by the server.",
+}, []string{"grpc_type", "grpc_service", "grpc_method"})
+
+func init() {
+	grpc_prometheus.EnableHandlingTimeHistogram()
+}
+
+const (
+	unary        = "unary"
+	clientStream = "client_stream"
+	serverStream = "server_stream"
+	bidiStream   = "bidi_stream"
+)
+
+// splitMethod splits /package.Service/Method
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.Index(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", "unknown"
+}
+
+func streamType(info *grpc.StreamServerInfo) string {
+	switch {
+	case info.IsClientStream && info.IsServerStream:
+		return bidiStream
+	case info.IsClientStream:
+		return clientStream
+	case info.IsServerStream:
+		return serverStream
+	}
+	return unary
+}
+
+// inFlight counts a call until the returned function is called
+func inFlight(kind, fullMethod string) func() {
+	service, method := splitMethod(fullMethod)
+	gauge := serverInFlight.WithLabelValues(kind, service, method)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// UnaryServerInterceptor records the metrics of unary calls. It should be
+// the first interceptor so that calls rejected by the others are counted.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		defer inFlight(unary, info.FullMethod)()
+		return grpc_prometheus.UnaryServerInterceptor(ctx, req, info, handler)
+	}
+}
+
+// StreamServerInterceptor records the metrics of streaming calls, and the
+// messages they exchange
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		defer inFlight(streamType(info), info.FullMethod)()
+		return grpc_prometheus.StreamServerInterceptor(srv, ss, info, handler)
+	}
+}
+
+// Register initializes the metrics of the methods registered on s, so that
+// they are exported before their first call
+func Register(s *grpc.Server) {
+	grpc_prometheus.Register(s)
+}
@@ -0,0 +1,25 @@
+// Package metrics exposes the Prometheus metrics of the servers over HTTP,
+// and records the metrics of the RPCs they handle.
+//
+// Metrics are registered with the default Prometheus registry, usually in
+// package variables:
+//
+//	var requests = promauto.NewCounterVec(prometheus.CounterOpts{
+//		Name: "app_requests_total",
+//		Help: "Requests handled.",
+//	}, []string{"code"})
+//
+//	requests.WithLabelValues("OK").Inc()
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Handler serves the metrics of the default registry in the Prometheus
+// text exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
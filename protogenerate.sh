@@ -1 +1,2 @@
-protoc blog/blogpb/blog.proto --go_out=plugins=grpc:. 
\ No newline at end of file
+protoc blog/blogpb/blog.proto --go_out=plugins=grpc:. 
+protoc chaos/chaospb/chaos.proto --go_out=plugins=grpc:. 
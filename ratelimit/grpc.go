@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"greet/auth"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+var rejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grpc_server_rate_limited_total",
+	Help: "Total number of RPCs rejected by the rate limiter.",
+}, []string{"grpc_method", "reason"})
+
+// Caller identifies the caller of a request: the subject of its principal,
+// or the IP address of its peer when it is not authenticated
+func Caller(ctx context.Context) string {
+	if p, ok := auth.FromContext(ctx); ok {
+		return "subject:" + p.Subject
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return "ip:" + host
+		}
+		return "addr:" + p.Addr.String()
+	}
+	return ""
+}
+
+// pushbackTrailer is the trailer of gRFC A6 holding the wait before a retry
+// in milliseconds, which grpc-go honors unlike RetryInfo
+const pushbackTrailer = "grpc-retry-pushback-ms"
+
+// exhausted returns the ResourceExhausted error telling the caller when to
+// retry, both as RetryInfo and as the pushback trailer
+func exhausted(ctx context.Context, fullMethod string, wait time.Duration) error {
+	rejected.WithLabelValues(fullMethod, "rate").Inc()
+	// Round up so that retrying after the delay succeeds
+	wait = wait.Truncate(time.Millisecond) + time.Millisecond
+	grpc.SetTrailer(ctx, metadata.Pairs(pushbackTrailer, strconv.FormatInt(wait.Milliseconds(), 10)))
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("Rate limit exceeded for %v, retry in %v", fullMethod, wait))
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// UnaryServerInterceptor rejects the unary calls of callers exceeding their
+// rate. It must run after the authentication interceptor to tell
+// authenticated callers apart.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if ok, wait := l.Allow(Caller(ctx), info.FullMethod); !ok {
+			return nil, exhausted(ctx, info.FullMethod, wait)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects the streaming calls of callers exceeding
+// their rate or keeping too many streams open. It must run after the
+// authentication interceptor to tell authenticated callers apart.
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		caller := Caller(ss.Context())
+		if ok, wait := l.Allow(caller, info.FullMethod); !ok {
+			return exhausted(ss.Context(), info.FullMethod, wait)
+		}
+		ok, closeStream := l.OpenStream(caller, info.FullMethod)
+		if !ok {
+			rejected.WithLabelValues(info.FullMethod, "streams").Inc()
+			return status.Errorf(codes.ResourceExhausted, fmt.Sprintf("Too many concurrent streams for %v", info.FullMethod))
+		}
+		defer closeStream()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,204 @@
+// Package ratelimit protects the gRPC servers from noisy callers with a
+// token bucket per caller, and bounds the streams each caller keeps open.
+//
+// Callers are told apart by the subject of their Principal once
+// authenticated, and by their IP address otherwise. Limits apply to every
+// method unless a rule matching the method overrides them; the methods
+// matched by the same rule share the budget of the caller.
+package ratelimit
+
+import (
+	"fmt"
+	"greet/auth"
+	"math"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limit bounds the calls and the concurrent streams of a caller
+type Limit struct {
+	// Rate is the number of calls per second a caller may sustain, zero
+	// or less for no limit
+	Rate float64
+	// Burst is the number of calls a caller may make at once, at least 1
+	// when Rate is set
+	Burst int
+	// MaxStreams is the number of streaming calls a caller may keep open
+	// at once, zero or less for no limit
+	MaxStreams int
+}
+
+// Rule overrides the limit of the methods matching Pattern, a full method
+// name or a pattern such as "/blog.BlogService/*"
+type Rule struct {
+	Pattern string
+	Limit   Limit
+}
+
+// Limiter holds the token buckets and the open streams of the callers
+type Limiter struct {
+	defaults Limit
+	rules    []Rule
+	// Now returns the current time, defaults to time.Now
+	Now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	streams   map[string]int
+	lastSweep time.Time
+}
+
+// New returns a limiter applying defaults to the methods matched by none of
+// the rules. The most specific rule matching a method wins: the exact
+// method name, then the longest pattern.
+func New(defaults Limit, rules []Rule) (*Limiter, error) {
+	if err := check(defaults); err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		if _, err := path.Match(r.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("ratelimit: %q: %v", r.Pattern, err)
+		}
+		if err := check(r.Limit); err != nil {
+			return nil, fmt.Errorf("ratelimit: %q: %v", r.Pattern, err)
+		}
+	}
+	sorted := append([]Rule(nil), rules...)
+	sort.Slice(sorted, func(i, j int) bool {
+		si, sj := specificity(sorted[i].Pattern), specificity(sorted[j].Pattern)
+		if si != sj {
+			return si > sj
+		}
+		return sorted[i].Pattern < sorted[j].Pattern
+	})
+	return &Limiter{
+		defaults: defaults,
+		rules:    sorted,
+		buckets:  map[string]*bucket{},
+		streams:  map[string]int{},
+	}, nil
+}
+
+func check(l Limit) error {
+	if l.Rate > 0 && l.Burst < 1 {
+		return fmt.Errorf("burst must be at least 1 with a rate of %v", l.Rate)
+	}
+	return nil
+}
+
+// specificity ranks exact method names above patterns, and longer
+// patterns above shorter ones
+func specificity(pattern string) int {
+	for _, c := range pattern {
+		if c == '*' || c == '?' || c == '[' {
+			return len(pattern)
+		}
+	}
+	return math.MaxInt32
+}
+
+// limit returns the limit of fullMethod and the name of the budget it
+// draws from
+func (l *Limiter) limit(fullMethod string) (Limit, string) {
+	for _, r := range l.rules {
+		if auth.MatchMethod([]string{r.Pattern}, fullMethod) {
+			return r.Limit, r.Pattern
+		}
+	}
+	return l.defaults, ""
+}
+
+func (l *Limiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// bucket holds the tokens of a caller, refilled continuously at the rate
+// of its limit up to its burst
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// full reports whether the bucket has refilled by now, making it the same
+// as a new one
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst)
+}
+
+// Allow takes a token from the bucket of caller for fullMethod. When it is
+// empty, Allow returns false along with the time until the next token.
+func (l *Limiter) Allow(caller, fullMethod string) (bool, time.Duration) {
+	limit, budget := l.limit(fullMethod)
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	now := l.now()
+	key := budget + "\x00" + caller
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep forgets the buckets refilled since their last call, at most once a
+// minute, so that callers gone away are not remembered
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// OpenStream counts a stream of caller for fullMethod. It returns false
+// when the caller already has as many streams open as allowed, and
+// otherwise a function closing the stream.
+func (l *Limiter) OpenStream(caller, fullMethod string) (bool, func()) {
+	limit, budget := l.limit(fullMethod)
+	if limit.MaxStreams <= 0 {
+		return true, func() {}
+	}
+	key := budget + "\x00" + caller
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.streams[key] >= limit.MaxStreams {
+		return false, nil
+	}
+	l.streams[key]++
+	var once sync.Once
+	return true, func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.streams[key]--; l.streams[key] <= 0 {
+				delete(l.streams, key)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package rbac
+
+import (
+	"context"
+	"greet/auth"
+	"greet/filewatch"
+	"greet/logging"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Enforcer checks every call against the current policy. In audit only
+// mode denied calls are logged but still go through, which allows trying
+// a policy on live traffic before enforcing it.
+type Enforcer struct {
+	path      string
+	auditOnly bool
+
+	mu     sync.RWMutex
+	policy *Policy
+}
+
+// NewEnforcer loads the policy file at path
+func NewEnforcer(path string, auditOnly bool) (*Enforcer, error) {
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Enforcer{path: path, auditOnly: auditOnly, policy: policy}, nil
+}
+
+// Reload reads the policy file again, keeping the current policy if the
+// new one is invalid
+func (e *Enforcer) Reload() error {
+	policy, err := LoadPolicy(e.path)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.policy = policy
+	e.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the policy whenever its file changes, until the returned
+// watcher is closed
+func (e *Enforcer) Watch(interval time.Duration) *filewatch.Watcher {
+	return filewatch.New(interval, func() {
+		if err := e.Reload(); err != nil {
+			logging.Error("Keeping the previous RBAC policy", logging.F("path", e.path), logging.Err(err))
+			return
+		}
+		logging.Info("RBAC policy reloaded", logging.F("path", e.path))
+	}, e.path)
+}
+
+// authorize returns the error denying the call, or nil when it is allowed
+func (e *Enforcer) authorize(ctx context.Context, fullMethod string) error {
+	principal, _ := auth.FromContext(ctx)
+	e.mu.RLock()
+	allowed := e.policy.Allowed(principal, fullMethod)
+	e.mu.RUnlock()
+	if allowed {
+		return nil
+	}
+
+	subject := "anonymous caller"
+	if principal != nil {
+		subject = principal.Subject
+	}
+	if e.auditOnly {
+		logging.FromContext(ctx).Warn("RBAC audit: call would be denied", logging.F("subject", subject))
+		return nil
+	}
+	logging.FromContext(ctx).Warn("RBAC denied the call", logging.F("subject", subject))
+	if principal == nil {
+		return status.Error(codes.Unauthenticated, "Authentication required")
+	}
+	return status.Errorf(codes.PermissionDenied, "%v is not allowed to call %v", subject, fullMethod)
+}
+
+// UnaryServerInterceptor authorizes unary calls, it must run after the
+// authentication interceptor
+func (e *Enforcer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := e.authorize(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authorizes streaming calls, it must run after
+// the authentication interceptor
+func (e *Enforcer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := e.authorize(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,124 @@
+// Package rbac authorizes gRPC calls with a role based access control
+// policy loaded from a YAML file, such as:
+//
+//	# Methods anybody may call, even without credentials
+//	public:
+//	  - /blog.BlogService/ReadBlog
+//	roles:
+//	  reader:
+//	    methods:
+//	      - /blog.BlogService/ReadBlog
+//	      - /blog.BlogService/ListBlog
+//	  editor:
+//	    inherits: [reader]
+//	    methods:
+//	      - /blog.BlogService/CreateBlog
+//	      - /blog.BlogService/UpdateBlog
+//	  admin:
+//	    methods: ["*"]
+//
+// Methods are matched with auth.MatchMethod, so "/blog.BlogService/*"
+// grants every method of the service. A call is allowed when its method is
+// public or one of the roles of the authenticated principal grants it, and
+// denied otherwise. Health checks are always allowed, as they are answered
+// without credentials so that probes need none.
+package rbac
+
+import (
+	"fmt"
+	"greet/auth"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Role grants methods, and the methods of the roles it inherits
+type Role struct {
+	Inherits []string `yaml:"inherits"`
+	Methods  []string `yaml:"methods"`
+}
+
+// Policy maps roles to the methods they may call
+type Policy struct {
+	Public []string        `yaml:"public"`
+	Roles  map[string]Role `yaml:"roles"`
+
+	// Methods granted by each role, including inherited ones
+	granted map[string][]string
+}
+
+// healthMethods prefixes the methods of the health service, which no
+// policy can deny
+const healthMethods = "/grpc.health.v1.Health/"
+
+// LoadPolicy reads and validates a policy file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy parses and validates the content of a policy file
+func ParsePolicy(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("rbac: invalid policy: %v", err)
+	}
+	p.granted = map[string][]string{}
+	names := make([]string, 0, len(p.Roles))
+	for name := range p.Roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		methods, err := p.resolve(name, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		p.granted[name] = methods
+	}
+	return p, nil
+}
+
+// resolve returns the methods granted by role, following inheritance
+func (p *Policy) resolve(role string, visiting map[string]bool) ([]string, error) {
+	if visiting[role] {
+		return nil, fmt.Errorf("rbac: role %q inherits from itself", role)
+	}
+	r, ok := p.Roles[role]
+	if !ok {
+		return nil, fmt.Errorf("rbac: unknown role %q", role)
+	}
+	visiting[role] = true
+	defer delete(visiting, role)
+	methods := append([]string{}, r.Methods...)
+	for _, parent := range r.Inherits {
+		inherited, err := p.resolve(parent, visiting)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, inherited...)
+	}
+	return methods, nil
+}
+
+// Allowed reports whether principal, nil for anonymous callers, may call
+// fullMethod. Roles unknown to the policy grant nothing.
+func (p *Policy) Allowed(principal *auth.Principal, fullMethod string) bool {
+	if strings.HasPrefix(fullMethod, healthMethods) || auth.MatchMethod(p.Public, fullMethod) {
+		return true
+	}
+	if principal == nil {
+		return false
+	}
+	for _, role := range principal.Roles {
+		if auth.MatchMethod(p.granted[role], fullMethod) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package rbac
+
+import (
+	"context"
+	"greet/auth"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testPolicy = `
+public:
+  - /blog.BlogService/ReadBlog
+roles:
+  reader:
+    methods:
+      - /blog.BlogService/ReadBlog
+      - /blog.BlogService/ListBlog
+  editor:
+    inherits: [reader]
+    methods:
+      - /blog.BlogService/CreateBlog
+  greeter:
+    methods:
+      - /greet.GreetService/*
+  admin:
+    methods: ["*"]
+`
+
+func TestPolicyAllowed(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("ParsePolicy: %v", err)
+	}
+	principal := func(roles ...string) *auth.Principal {
+		return &auth.Principal{Subject: "alice", Roles: roles}
+	}
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		method    string
+		want      bool
+	}{
+		{"public method for anonymous callers", nil, "/blog.BlogService/ReadBlog", true},
+		{"public method for any role", principal("unknown"), "/blog.BlogService/ReadBlog", true},
+		{"anonymous callers are denied the other methods", nil, "/blog.BlogService/ListBlog", false},
+		{"role granting the method", principal("reader"), "/blog.BlogService/ListBlog", true},
+		{"role not granting the method", principal("reader"), "/blog.BlogService/CreateBlog", false},
+		{"inherited method", principal("editor"), "/blog.BlogService/ListBlog", true},
+		{"own method of an inheriting role", principal("editor"), "/blog.BlogService/CreateBlog", true},
+		{"inheritance only goes down", principal("reader"), "/blog.BlogService/CreateBlog", false},
+		{"service wildcard", principal("greeter"), "/greet.GreetService/Greet", true},
+		{"service wildcard stops at its service", principal("greeter"), "/blog.BlogService/ListBlog", false},
+		{"global wildcard", principal("admin"), "/chaos.ChaosService/SetFault", true},
+		{"any of the roles", principal("greeter", "editor"), "/blog.BlogService/CreateBlog", true},
+		{"unknown role grants nothing", principal("owner"), "/blog.BlogService/ListBlog", false},
+		{"no role grants nothing", principal(), "/blog.BlogService/ListBlog", false},
+		{"unlisted method is denied", principal("editor"), "/blog.BlogService/DeleteBlog", false},
+		{"health checks for anonymous callers", nil, "/grpc.health.v1.Health/Check", true},
+		{"health watches for any role", principal("unknown"), "/grpc.health.v1.Health/Watch", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Allowed(tt.principal, tt.method); got != tt.want {
+				t.Errorf("Allowed(%v) = %v, want %v", tt.method, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyPolicyDeniesAllButHealth(t *testing.T) {
+	p, err := ParsePolicy([]byte("{}"))
+	if err != nil {
+		t.Fatalf("ParsePolicy: %v", err)
+	}
+	if p.Allowed(&auth.Principal{Subject: "alice", Roles: []string{"admin"}}, "/greet.GreetService/Greet") {
+		t.Error("an empty policy allowed a call")
+	}
+	if !p.Allowed(nil, "/grpc.health.v1.Health/Check") {
+		t.Error("an empty policy denied a health check")
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr string
+	}{
+		{"inheritance cycle", "roles:\n  a:\n    inherits: [b]\n  b:\n    inherits: [a]\n", "inherits from itself"},
+		{"unknown parent role", "roles:\n  a:\n    inherits: [missing]\n", "unknown role"},
+		{"unknown field", "roles:\n  a:\n    method: [/greet.GreetService/Greet]\n", "invalid policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePolicy([]byte(tt.policy))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParsePolicy: %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExamplePolicy(t *testing.T) {
+	p, err := LoadPolicy("policy.example.yaml")
+	if err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if !p.Allowed(nil, "/blog.BlogService/ReadBlog") {
+		t.Error("the example policy denies anonymous reads")
+	}
+	if p.Allowed(&auth.Principal{Subject: "alice", Roles: []string{"reader"}}, "/blog.BlogService/DeleteBlog") {
+		t.Error("the example policy lets readers delete blogs")
+	}
+}
+
+func TestEnforcer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(testPolicy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	call := func(e *Enforcer, principal *auth.Principal, method string) error {
+		ctx := context.Background()
+		if principal != nil {
+			ctx = auth.NewContext(ctx, principal)
+		}
+		handler := func(context.Context, interface{}) (interface{}, error) { return nil, nil }
+		_, err := e.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+	reader := &auth.Principal{Subject: "alice", Roles: []string{"reader"}}
+
+	e, err := NewEnforcer(path, false)
+	if err != nil {
+		t.Fatalf("NewEnforcer: %v", err)
+	}
+	if err := call(e, reader, "/blog.BlogService/ListBlog"); err != nil {
+		t.Errorf("allowed call: %v", err)
+	}
+	if err := call(e, nil, "/grpc.health.v1.Health/Check"); err != nil {
+		t.Errorf("health check: %v", err)
+	}
+	if code := status.Code(call(e, reader, "/blog.BlogService/CreateBlog")); code != codes.PermissionDenied {
+		t.Errorf("denied call of an authenticated caller: %v, want %v", code, codes.PermissionDenied)
+	}
+	if code := status.Code(call(e, nil, "/blog.BlogService/CreateBlog")); code != codes.Unauthenticated {
+		t.Errorf("denied call of an anonymous caller: %v, want %v", code, codes.Unauthenticated)
+	}
+
+	audit, err := NewEnforcer(path, true)
+	if err != nil {
+		t.Fatalf("NewEnforcer: %v", err)
+	}
+	if err := call(audit, reader, "/blog.BlogService/CreateBlog"); err != nil {
+		t.Errorf("audit only mode denied a call: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"greet/filewatch"
+	"greet/logging"
+	"sync"
+	"time"
+)
+
+// Certificates expiring within this delay are logged
+const expiryWarning = 30 * 24 * time.Hour
+
+// Reloader holds a certificate with its key and a CA bundle loaded from
+// files, and swaps them atomically when the files change. Replacements
+// which cannot be loaded, or whose certificate is not currently valid, are
+// rejected and the previous ones kept.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu          sync.RWMutex
+	cert        *tls.Certificate
+	pool        *x509.CertPool
+	lastWarning time.Time
+}
+
+// NewReloader loads the certificate and key when certFile is not empty,
+// and the CA bundle when caFile is not empty
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files again and swaps them in if they are all valid
+func (r *Reloader) Reload() error {
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return err
+		}
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+			return fmt.Errorf("tlsutil: certificate %v is only valid from %v to %v", r.certFile, leaf.NotBefore, leaf.NotAfter)
+		}
+		c.Leaf = leaf
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		p, err := LoadCertPool(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = p
+	}
+	r.mu.Lock()
+	r.cert = cert
+	r.pool = pool
+	r.lastWarning = time.Time{}
+	r.mu.Unlock()
+	r.checkExpiry()
+	return nil
+}
+
+// Watch reloads the files whenever they change, until the returned watcher
+// is closed
+func (r *Reloader) Watch(interval time.Duration) *filewatch.Watcher {
+	var paths []string
+	for _, p := range []string{r.certFile, r.keyFile, r.caFile} {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return filewatch.New(interval, func() {
+		if err := r.Reload(); err != nil {
+			logging.Error("Keeping the previous TLS certificates", logging.F("paths", paths), logging.Err(err))
+			return
+		}
+		logging.Info("TLS certificates reloaded", logging.F("paths", paths))
+	}, paths...)
+}
+
+// checkExpiry logs, at most once a day, when the certificate expires soon
+func (r *Reloader) checkExpiry() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert == nil || time.Since(r.lastWarning) < 24*time.Hour {
+		return
+	}
+	left := time.Until(r.cert.Leaf.NotAfter)
+	if left < expiryWarning {
+		logging.Warn("TLS certificate expires soon", logging.F("cert", r.certFile), logging.F("expires_in", left.Round(time.Hour)), logging.F("not_after", r.cert.Leaf.NotAfter))
+		r.lastWarning = time.Now()
+	}
+}
+
+func (r *Reloader) certificate() (*tls.Certificate, error) {
+	r.checkExpiry()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cert == nil {
+		return nil, errors.New("tlsutil: no certificate configured")
+	}
+	return r.cert, nil
+}
+
+func (r *Reloader) certPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// ServerConfig returns a configuration presenting the current certificate.
+// When the reloader has a CA bundle, clients must present a certificate
+// signed by one of its CAs (mutual TLS).
+func (r *Reloader) ServerConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Set here as configurations returned by GetConfigForClient do not
+		// go through the gRPC credentials which add it
+		NextProtos: []string{"h2"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		},
+	}
+	if r.caFile != "" {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		// The client CAs are captured per handshake, so that a reload
+		// applies to the following connections
+		cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c := cfg.Clone()
+			c.GetConfigForClient = nil
+			c.ClientCAs = r.certPool()
+			return c, nil
+		}
+	}
+	return cfg
+}
+
+// ClientConfig returns a configuration verifying servers with the current
+// CA bundle, or the system roots when the reloader has none, and
+// presenting the current certificate if any. serverName overrides the name
+// expected in the server certificate.
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	cfg := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if r.certFile != "" {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		}
+	}
+	if r.caFile != "" {
+		// RootCAs cannot be swapped on a live configuration, so the
+		// verification is done against the current pool instead
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return r.verifyServer(cs)
+		}
+	}
+	return cfg
+}
+
+func (r *Reloader) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tlsutil: server presented no certificate")
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         r.certPool(),
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
@@ -0,0 +1,58 @@
+// Package tlsutil builds the TLS configurations shared by the servers and
+// clients of the greet and blog services.
+//
+// Certificates, keys and CA bundles are read through a Reloader, so that
+// they can be rotated on disk without restarting the processes.
+package tlsutil
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+)
+
+// LoadCertPool reads a PEM bundle of CA certificates
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	found := false
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("tlsutil: invalid certificate in %v: %v", caFile, err)
+		}
+		pool.AddCert(cert)
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("tlsutil: no certificate found in %v", caFile)
+	}
+	return pool, nil
+}
+
+// IsLoopback reports whether a listen address only accepts local
+// connections. Unspecified hosts such as "" or "0.0.0.0" are not loopback.
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
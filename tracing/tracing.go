@@ -0,0 +1,105 @@
+// Package tracing sets up OpenTelemetry to record distributed traces of the
+// gRPC calls and of the storage operations they make.
+//
+// Trace context crosses process boundaries in the W3C traceparent and
+// tracestate headers, carried as gRPC metadata by the otelgrpc interceptors.
+// Spans are batched and exported to stdout or to an OTLP collector by the
+// tracer provider installed by Setup. Until one is installed spans still
+// propagate the trace context but are never recorded.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"greet/logging"
+	"net/url"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultOTLPEndpoint is the OTLP/HTTP traces endpoint of a local collector
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// Options configures the tracer provider
+type Options struct {
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string
+	// SampleRatio is the fraction of new traces recorded, traces started
+	// by a caller follow its decision
+	SampleRatio float64
+}
+
+// Tracer returns the tracer of the spans created by this module
+func Tracer() trace.Tracer {
+	return otel.Tracer("greet")
+}
+
+// newExporter returns the exporter named kind: "stdout" writing spans as
+// JSON lines, or "otlp" posting them to endpoint with OTLP/HTTP, or
+// DefaultOTLPEndpoint when empty
+func newExporter(kind, endpoint string) (sdktrace.SpanExporter, error) {
+	switch kind {
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		if endpoint == "" {
+			endpoint = DefaultOTLPEndpoint
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("tracing: invalid OTLP endpoint %q", endpoint)
+		}
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host), otlptracehttp.WithURLPath(u.Path)}
+		if u.Scheme == "http" {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(context.Background(), opts...)
+	}
+	return nil, fmt.Errorf("tracing: unknown exporter %q", kind)
+}
+
+// Setup installs the W3C trace context propagator and a tracer provider
+// exporting with the exporter named kind, and returns the provider to be
+// shut down when the process exits. An empty or "none" kind installs no
+// provider and returns nil.
+func Setup(kind, endpoint string, opts Options) (*sdktrace.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if kind == "" || kind == "none" {
+		return nil, nil
+	}
+	exporter, err := newExporter(kind, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(opts.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logging.Error("Failed to export spans", logging.Err(err))
+	}))
+	return provider, nil
+}
+
+// LogFields returns the IDs of the trace and span carried by ctx, added to
+// the log records of a call
+func LogFields(ctx context.Context) []logging.Field {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return []logging.Field{
+		logging.F("trace_id", sc.TraceID().String()),
+		logging.F("span_id", sc.SpanID().String()),
+	}
+}